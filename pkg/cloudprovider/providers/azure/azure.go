@@ -17,13 +17,26 @@ limitations under the License.
 package azure
 
 import (
+	"bytes"
 	"crypto/rsa"
 	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
 	"time"
 
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/kubernetes/pkg/cloudprovider"
 	"k8s.io/kubernetes/pkg/controller"
@@ -51,6 +64,28 @@ const (
 	backoffExponentDefault = 1.5
 	backoffDurationDefault = 5 // in seconds
 	backoffJitterDefault   = 1.0
+
+	// maxConcurrentOperationsDefault bounds how many long-running ARM operations (each polled
+	// to completion on its own goroutine by the generated client) this process will wait on at
+	// once, so a reconciliation storm can't spawn an unbounded number of concurrent pollers
+	// hammering the same handful of ARM endpoints.
+	maxConcurrentOperationsDefault = 32
+
+	vmTypeStandard = "standard"
+	vmTypeVMSS     = "vmss"
+	vmTypeMixed    = "mixed"
+
+	// azureObjectCacheTTL bounds how stale a cached VM/NIC/LoadBalancer/SecurityGroup/
+	// PublicIPAddress GET result is allowed to be. It's short enough that a single reconcile
+	// still sees changes it made itself moments earlier, but long enough to collapse the
+	// duplicate GETs a reconciliation storm produces.
+	azureObjectCacheTTL = 5 * time.Second
+
+	// cloudConfigSecretNamespaceDefault is used when Config.CloudConfigSecretNamespace is unset.
+	cloudConfigSecretNamespaceDefault = "kube-system"
+	// cloudConfigSecretKey is the key under which the full cloud-config file contents are
+	// expected to be stored in the CloudConfigSecretName secret.
+	cloudConfigSecretKey = "cloud-config"
 )
 
 // Config holds the configuration parsed from the --cloud-config flag
@@ -70,12 +105,42 @@ type Config struct {
 	VnetName string `json:"vnetName" yaml:"vnetName"`
 	// The name of the resource group that the Vnet is deployed in
 	VnetResourceGroup string `json:"vnetResourceGroup" yaml:"vnetResourceGroup"`
+	// (Optional) The ID of the subscription the Vnet (and its subnets) live in, when that's not
+	// SubscriptionID. Defaults to SubscriptionID.
+	VnetSubscriptionID string `json:"vnetSubscriptionID" yaml:"vnetSubscriptionID"`
 	// The name of the subnet that the cluster is deployed in
 	SubnetName string `json:"subnetName" yaml:"subnetName"`
 	// The name of the security group attached to the cluster's subnet
 	SecurityGroupName string `json:"securityGroupName" yaml:"securityGroupName"`
 	// (Optional in 1.6) The name of the route table attached to the subnet that the cluster is deployed in
 	RouteTableName string `json:"routeTableName" yaml:"routeTableName"`
+	// (Optional) The ID of the subscription the route table(s) named by RouteTableName/
+	// RouteTables live in, when that's not SubscriptionID. Defaults to SubscriptionID.
+	RouteTableSubscriptionID string `json:"routeTableSubscriptionID" yaml:"routeTableSubscriptionID"`
+	// (Optional) The ID of the subscription managed disks are created in and looked up from,
+	// when that's not SubscriptionID. Defaults to SubscriptionID.
+	DiskSubscriptionID string `json:"diskSubscriptionID" yaml:"diskSubscriptionID"`
+	// (Optional) Maps an availability set (agent pool) name to the route table that pod
+	// CIDR routes for its nodes should be programmed into. Node pools not listed here fall
+	// back to RouteTableName. Only needed for clusters spanning multiple subnets that each
+	// have their own route table.
+	RouteTables map[string]string `json:"routeTables" yaml:"routeTables"`
+	// (Optional) A "key=value" node label. Nodes carrying it (e.g. Azure CNI nodes, which
+	// get pod IPs directly from the subnet and manage their own routing) are skipped by
+	// CreateRoute instead of getting a route-table entry programmed for them.
+	ExcludeCNINodesLabel string `json:"excludeCNINodesLabel" yaml:"excludeCNINodesLabel"`
+	// (Optional) A "key=value" node label. Nodes carrying it are treated as externally
+	// managed (e.g. bare-metal or another cloud's instances joined to the cluster) and are
+	// skipped entirely by the Azure provider: no route-table entry, no load balancer backend
+	// pool membership, and no ARM existence check that could otherwise delete them as
+	// "not found".
+	ExcludeNodesLabel string `json:"excludeNodesLabel" yaml:"excludeNodesLabel"`
+	// (Optional) A prefix prepended to a node's Kubernetes name to derive its Azure VM name,
+	// and stripped back off to recover the node name from a VM name. Defaults to "" (the VM
+	// name is identical to the node name), which is the historical, and still overwhelmingly
+	// common, deployment. Set this when node names and VM names are related by a fixed prefix
+	// rather than being identical, e.g. clusters that join VMs named "aks-nodepool1-<node>".
+	VMNamePrefix string `json:"vmNamePrefix" yaml:"vmNamePrefix"`
 	// (Optional) The name of the availability set that should be used as the load balancer backend
 	// If this is set, the Azure cloudprovider will only add nodes from that availability set to the load
 	// balancer backend pool. If this is not set, and multiple agent pools (availability sets) are used, then
@@ -89,8 +154,36 @@ type Config struct {
 	AADClientSecret string `json:"aadClientSecret" yaml:"aadClientSecret"`
 	// The path of a client certificate for an AAD application with RBAC access to talk to Azure RM APIs
 	AADClientCertPath string `json:"aadClientCertPath" yaml:"aadClientCertPath"`
-	// The password of the client certificate for an AAD application with RBAC access to talk to Azure RM APIs
+	// (Optional) The password of the client certificate for an AAD application with RBAC access
+	// to talk to Azure RM APIs. Empty if the PKCS#12 file was exported without a password.
 	AADClientCertPassword string `json:"aadClientCertPassword" yaml:"aadClientCertPassword"`
+	// (Optional) The path to a projected Kubernetes service account token to exchange for an AAD
+	// access token via a federated identity credential, instead of a managed identity or an SP
+	// secret/certificate. Requires AADClientID to name the AAD application the federated identity
+	// credential is configured on. Mutually exclusive with UseManagedIdentityExtension,
+	// AADClientSecret, and AADClientCertPath.
+	AADFederatedTokenFile string `json:"aadFederatedTokenFile" yaml:"aadFederatedTokenFile"`
+
+	// (Optional) A second AAD application, used only for StorageAccountClient (storage account
+	// creation/deletion/key-listing), instead of the primary AADClientID/AADClientSecret/
+	// AADClientCertPath credentials. Leave unset to keep using the primary credentials for
+	// storage calls too, the historical behavior. Lets a component that only needs to read blob/
+	// file data (e.g. a CSI node plugin listing account keys to mount a volume) run with an
+	// identity scoped to just that, rather than the fully-privileged control-plane one that can
+	// also manage VMs, networking, and load balancers. Authenticates with a client secret or a
+	// client certificate, same as the primary credentials; managed identity and federated tokens
+	// aren't supported here since a second identity assigned to the same VM, or a second
+	// federated credential on the same service account, accomplish the same narrowing already.
+	StorageAADClientID string `json:"storageAADClientID" yaml:"storageAADClientID"`
+	// The ClientSecret for StorageAADClientID. Mutually exclusive with StorageAADClientCertPath.
+	StorageAADClientSecret string `json:"storageAADClientSecret" yaml:"storageAADClientSecret"`
+	// The path of a client certificate for StorageAADClientID. Mutually exclusive with
+	// StorageAADClientSecret.
+	StorageAADClientCertPath string `json:"storageAADClientCertPath" yaml:"storageAADClientCertPath"`
+	// (Optional) The password of the client certificate named by StorageAADClientCertPath. Empty
+	// if the PKCS#12 file was exported without a password.
+	StorageAADClientCertPassword string `json:"storageAADClientCertPassword" yaml:"storageAADClientCertPassword"`
+
 	// Enable exponential backoff to manage resource request retries
 	CloudProviderBackoff bool `json:"cloudProviderBackoff" yaml:"cloudProviderBackoff"`
 	// Backoff retry limit
@@ -103,16 +196,143 @@ type Config struct {
 	CloudProviderBackoffJitter float64 `json:"cloudProviderBackoffJitter" yaml:"cloudProviderBackoffJitter"`
 	// Enable rate limiting
 	CloudProviderRateLimit bool `json:"cloudProviderRateLimit" yaml:"cloudProviderRateLimit"`
-	// Rate limit QPS
+	// Rate limit QPS, applied to read (GET) ARM/storage requests. Also the default for
+	// CloudProviderRateLimitQPSWrite when that's left unset.
 	CloudProviderRateLimitQPS float32 `json:"cloudProviderRateLimitQPS" yaml:"cloudProviderRateLimitQPS"`
-	// Rate limit Bucket Size
+	// Rate limit Bucket Size, applied to read (GET) ARM/storage requests. Also the default for
+	// CloudProviderRateLimitBucketWrite when that's left unset.
 	CloudProviderRateLimitBucket int `json:"cloudProviderRateLimitBucket" yaml:"cloudProviderRateLimitBucket"`
+	// (Optional) Rate limit QPS for mutating (PUT/POST/PATCH/DELETE) requests, kept independent
+	// of CloudProviderRateLimitQPS so a burst of List/Get traffic (e.g. a controller-manager
+	// restart relisting every LoadBalancer/NIC/NSG) can't delay a latency-sensitive write like a
+	// disk attach PUT. Defaults to CloudProviderRateLimitQPS when unset.
+	CloudProviderRateLimitQPSWrite float32 `json:"cloudProviderRateLimitQPSWrite" yaml:"cloudProviderRateLimitQPSWrite"`
+	// (Optional) Rate limit Bucket Size for mutating requests. Defaults to
+	// CloudProviderRateLimitBucket when unset.
+	CloudProviderRateLimitBucketWrite int `json:"cloudProviderRateLimitBucketWrite" yaml:"cloudProviderRateLimitBucketWrite"`
+
+	// Enable a fail-fast circuit breaker per ARM client (compute, network, storage) that opens
+	// after CloudProviderCircuitBreakerFailureThreshold consecutive failed requests, so a sick
+	// Azure client can't keep piling up blocked goroutines across every controller that calls it.
+	CloudProviderCircuitBreaker bool `json:"cloudProviderCircuitBreaker" yaml:"cloudProviderCircuitBreaker"`
+	// (Optional) Consecutive failures needed to open the circuit. Defaults to
+	// circuitBreakerFailureThresholdDefault when unset.
+	CloudProviderCircuitBreakerFailureThreshold int `json:"cloudProviderCircuitBreakerFailureThreshold" yaml:"cloudProviderCircuitBreakerFailureThreshold"`
+	// (Optional) How long, in seconds, an open circuit fails fast before allowing another
+	// request through to probe recovery. Defaults to circuitBreakerCooldownDefault when unset.
+	CloudProviderCircuitBreakerCooldownSeconds int `json:"cloudProviderCircuitBreakerCooldownSeconds" yaml:"cloudProviderCircuitBreakerCooldownSeconds"`
 
 	// Use instance metadata service where possible
 	UseInstanceMetadata bool `json:"useInstanceMetadata" yaml:"useInstanceMetadata"`
 
 	// Use managed service identity for the virtual machine to access Azure ARM APIs
 	UseManagedIdentityExtension bool `json:"useManagedIdentityExtension"`
+	// (Optional) The client ID of the user-assigned identity to use when the virtual machine
+	// carries more than one and UseManagedIdentityExtension is set. Ignored (the VM's
+	// system-assigned identity, or its sole user-assigned one, is used) when empty.
+	UserAssignedIdentityID string `json:"userAssignedIdentityID" yaml:"userAssignedIdentityID"`
+
+	// Tags is a comma-separated list of key=value pairs that are applied to every
+	// LB, public IP, and NSG that the cloud provider creates for a Service. Individual
+	// Services can add to or override these via the ServiceAnnotationLoadBalancerResourceTags
+	// annotation.
+	Tags string `json:"tags" yaml:"tags"`
+
+	// (Optional) A cluster name or ID appended to the user agent on every ARM and Azure Storage
+	// request, so a support case or an ARM activity log entry can be attributed to a specific
+	// cluster. Distinct from the --cluster-name flag threaded through the LoadBalancer/Routes
+	// interface methods at call time, since ARM clients are built once at startup, before that
+	// value is available to this package.
+	ClusterName string `json:"clusterName" yaml:"clusterName"`
+
+	// EnableTCPReset is the cluster-wide default for whether idle TCP connections on
+	// Standard LB rules are reset with a TCP RST rather than silently dropped. Can be
+	// overridden per Service via ServiceAnnotationLoadBalancerEnableTCPReset.
+	EnableTCPReset bool `json:"enableTcpReset" yaml:"enableTcpReset"`
+
+	// (Optional) The type of nodes backing the cluster: "standard" (individual
+	// VirtualMachines, each with its own availability set), "vmss" (nodes are instances of a
+	// Virtual Machine Scale Set), or "mixed" (some node pools are one, some are the other; each
+	// node is looked up as whichever it turns out to be). Defaults to "standard".
+	VMType string `json:"vmType" yaml:"vmType"`
+	// (Optional) How often, in seconds, to poll every VM's power state in the background so
+	// InstanceShutdownByProviderID can answer from cache instead of a live ARM call on the
+	// node controller's hot path. 0 (the default) disables the poller and falls back to
+	// synchronous lookups.
+	PowerStatePollIntervalInSeconds int `json:"powerStatePollIntervalInSeconds" yaml:"powerStatePollIntervalInSeconds"`
+	// (Optional) The name of the NIC to treat as primary on multi-NIC nodes, overriding the
+	// "primary" flag Azure reports on the VM's NetworkProfile. Nodes with only one NIC always
+	// use it regardless of this setting.
+	PrimaryInterfaceName string `json:"primaryInterfaceName" yaml:"primaryInterfaceName"`
+	// (Optional) A loopback host:port (e.g. "127.0.0.1:10269") to serve a JSON dump of the
+	// provider's cache sizes, cached storage account state, and per-operation ARM call counts
+	// at /debug/azure, for troubleshooting. Empty (the default) disables the endpoint. Refused
+	// at startup if the host isn't a loopback address, since the dump can include resource
+	// group and subnet identifiers.
+	CloudProviderDebugAddr string `json:"cloudProviderDebugAddr" yaml:"cloudProviderDebugAddr"`
+	// (Optional) The maximum number of long-running ARM operations (VM/LB/NIC/NSG/PublicIP/Disk
+	// CreateOrUpdate and Delete calls) this process will wait on concurrently. Defaults to
+	// maxConcurrentOperationsDefault. Extra callers block until a slot frees up rather than
+	// piling on more concurrent pollers against the same ARM endpoints.
+	CloudProviderMaxConcurrentOperations int `json:"cloudProviderMaxConcurrentOperations" yaml:"cloudProviderMaxConcurrentOperations"`
+	// (Optional) Path to an append-only file that every mutating (PUT/DELETE/PATCH/POST) ARM
+	// request is logged to as a JSON record (operation, target resource, result, duration), to
+	// satisfy change-tracking requirements. Empty (the default) disables audit logging.
+	CloudProviderAuditLogPath string `json:"cloudProviderAuditLogPath" yaml:"cloudProviderAuditLogPath"`
+
+	// (Optional) The name of a secret holding the full cloud-config contents (under the
+	// "cloud-config" key), used instead of the file passed via --cloud-config. Requires
+	// Initialize to be called with a working clientset, so this only takes effect for
+	// components (e.g. the cloud-controller-manager) that call it; a --cloud-config file with no
+	// credentials is still needed to get past NewCloud until then. Lets nodes run with no Azure
+	// credentials on disk at all, with the secret readable only by the control plane.
+	CloudConfigSecretName string `json:"cloudConfigSecretName" yaml:"cloudConfigSecretName"`
+	// (Optional) The namespace of the secret named by CloudConfigSecretName. Defaults to
+	// "kube-system".
+	CloudConfigSecretNamespace string `json:"cloudConfigSecretNamespace" yaml:"cloudConfigSecretNamespace"`
+
+	// (Optional) An outbound HTTP/HTTPS proxy URL (e.g. "http://proxy.internal:3128") that every
+	// ARM and Azure Storage client the provider constructs should send its requests through.
+	// Unset by default, in which case Go's standard HTTPS_PROXY/HTTP_PROXY/NO_PROXY environment
+	// variables are still honored, since that's the net/http default; this setting only matters
+	// for clusters that need a proxy configured that the controller-manager process's own
+	// environment doesn't carry.
+	HTTPProxy string `json:"httpProxy" yaml:"httpProxy"`
+
+	// (Optional) Disables the Routes interface (node CIDR route reconciliation against a route
+	// table), for clusters using another controller (e.g. a CNI plugin that programs its own
+	// routing) to manage pod routing instead.
+	DisableRoutes bool `json:"disableRoutes" yaml:"disableRoutes"`
+	// (Optional) Disables the LoadBalancer interface, for clusters using another controller
+	// (e.g. an ingress controller, or a dedicated cloud-controller-manager add-on) to reconcile
+	// Services of type LoadBalancer instead.
+	DisableLoadBalancer bool `json:"disableLoadBalancer" yaml:"disableLoadBalancer"`
+	// (Optional) Disables the Zones interface (topology.kubernetes.io/zone and .../region node
+	// labeling), for clusters that set those labels another way.
+	DisableZones bool `json:"disableZones" yaml:"disableZones"`
+	// (Optional) Disables the blob/managed disk controller backing the in-tree azure-disk volume
+	// plugin, for clusters that provision and attach disks entirely through the Azure Disk CSI
+	// driver instead.
+	DisableDiskController bool `json:"disableDiskController" yaml:"disableDiskController"`
+	// (Optional) How long DetachDiskByName tolerates repeated failures to detach the same disk
+	// (e.g. a VM that stopped responding to ARM updates, or a blob lease the fabric never
+	// released) before forcing it through: dropping the disk from the VM's data disk list
+	// unconditionally and breaking its blob lease directly, rather than continuing to retry the
+	// same failing update. 0 (the default) never forces a detach - callers keep retrying
+	// indefinitely, the historical behavior.
+	DetachDiskForceTimeoutMinutes int `json:"detachDiskForceTimeoutMinutes" yaml:"detachDiskForceTimeoutMinutes"`
+
+	// (Optional) Pins the api-version query parameter sent on every compute (VirtualMachines,
+	// VMSS, VMSSVM, Disks) ARM request, overriding the version the vendored SDK's generated
+	// clients default to. Needed for clouds, such as Azure Stack, whose control plane lags behind
+	// the versions the SDK in this tree was generated against.
+	ComputeAPIVersion string `json:"computeAPIVersion" yaml:"computeAPIVersion"`
+	// (Optional) The network ARM client equivalent of ComputeAPIVersion, applied to Subnets,
+	// RouteTables, Routes, Interfaces, LoadBalancer, PublicIPAddresses, and SecurityGroups.
+	NetworkAPIVersion string `json:"networkAPIVersion" yaml:"networkAPIVersion"`
+	// (Optional) The storage ARM client equivalent of ComputeAPIVersion, applied to
+	// StorageAccountClient.
+	StorageAPIVersion string `json:"storageAPIVersion" yaml:"storageAPIVersion"`
 }
 
 // Cloud holds the config and clients
@@ -127,11 +347,48 @@ type Cloud struct {
 	PublicIPAddressesClient  network.PublicIPAddressesClient
 	SecurityGroupsClient     network.SecurityGroupsClient
 	VirtualMachinesClient    compute.VirtualMachinesClient
+	VMSSClient               compute.VirtualMachineScaleSetsClient
+	VMSSVMClient             compute.VirtualMachineScaleSetVMsClient
 	StorageAccountClient     storage.AccountsClient
 	DisksClient              disk.DisksClient
-	operationPollRateLimiter flowcontrol.RateLimiter
+	operationPollRateLimiter      flowcontrol.RateLimiter
+	operationPollRateLimiterWrite flowcontrol.RateLimiter
 	resourceRequestBackoff   wait.Backoff
 	metadata                 *InstanceMetadata
+	kubeClient               kubernetes.Interface
+	routeUpdaters            map[string]*routeUpdater
+	routeUpdatersMu          sync.Mutex
+	vmSet                    VMSet
+	instanceExistsCache      *instanceExistsCache
+	powerState               *powerStateCache
+	// vmCache, nicCache, lbCache, nsgCache, and publicIPCache memoize the corresponding GET calls
+	// in azure_wrap.go for azureObjectCacheTTL; see azure_cache.go.
+	vmCache       *azureCache
+	nicCache      *azureCache
+	lbCache       *azureCache
+	nsgCache      *azureCache
+	publicIPCache *azureCache
+	// apiCallCounters counts every outbound ARM request by method and resource type, for the
+	// debug endpoint in azure_debug.go.
+	apiCallCounters *apiCallCounters
+	// operationPool bounds the number of long-running ARM operations awaited concurrently; see
+	// azure_operationpool.go.
+	operationPool *operationPool
+	// auditLogger, if non-nil (CloudProviderAuditLogPath is set), receives one record per
+	// mutating ARM request from auditSender.
+	auditLogger *auditLogger
+	// httpClient is shared by every ARM autorest.Client (as Sender) and every Azure Storage
+	// client (as HTTPClient) when HTTPProxy is set; nil otherwise, leaving each client on its
+	// own default, which already honors HTTPS_PROXY/NO_PROXY.
+	httpClient *http.Client
+
+	// eventBroadcaster/eventRecorder let this provider surface Warning/Normal events on
+	// Kubernetes objects (e.g. a forced disk detach) instead of only glog, the same way gce's
+	// provider does. Both are nil until Initialize runs, since building them needs the
+	// clientset Initialize is handed - so any code path reachable before Initialize must treat a
+	// nil eventRecorder as "no event recorder available" and fall back to logging only.
+	eventBroadcaster record.EventBroadcaster
+	eventRecorder    record.EventRecorder
 
 	*BlobDiskController
 	*ManagedDiskController
@@ -157,6 +414,174 @@ func decodePkcs12(pkcs []byte, password string) (*x509.Certificate, *rsa.Private
 	return certificate, rsaPrivateKey, nil
 }
 
+// Environment variable names mirror the ones the Azure SDKs and az CLI already read, so
+// operators can point whatever secret-injection mechanism they already use for other Azure
+// tooling (e.g. a Secret mounted as env vars into the controller-manager pod) at the same
+// variables here, instead of rewriting the cloud-config file on every node to rotate credentials.
+const (
+	azureClientIDEnvVar           = "AZURE_CLIENT_ID"
+	azureClientSecretEnvVar       = "AZURE_CLIENT_SECRET"
+	azureTenantIDEnvVar           = "AZURE_TENANT_ID"
+	azureSubscriptionIDEnvVar     = "AZURE_SUBSCRIPTION_ID"
+	azureClientCertPathEnvVar     = "AZURE_CLIENT_CERTIFICATE_PATH"
+	azureClientCertPasswordEnvVar = "AZURE_CLIENT_CERTIFICATE_PASSWORD"
+	azureFederatedTokenFileEnvVar = "AZURE_FEDERATED_TOKEN_FILE"
+)
+
+// applyCredentialEnvironmentOverrides lets AZURE_CLIENT_ID/SECRET/TENANT and friends take
+// precedence over the equivalent fields parsed from the cloud-config file, so credentials can be
+// rotated by updating whatever injects the process's environment without touching node disks.
+func applyCredentialEnvironmentOverrides(config *Config) {
+	overrides := []struct {
+		envVar string
+		field  *string
+	}{
+		{azureClientIDEnvVar, &config.AADClientID},
+		{azureClientSecretEnvVar, &config.AADClientSecret},
+		{azureTenantIDEnvVar, &config.TenantID},
+		{azureSubscriptionIDEnvVar, &config.SubscriptionID},
+		{azureClientCertPathEnvVar, &config.AADClientCertPath},
+		{azureClientCertPasswordEnvVar, &config.AADClientCertPassword},
+		{azureFederatedTokenFileEnvVar, &config.AADFederatedTokenFile},
+	}
+	for _, override := range overrides {
+		if value, ok := os.LookupEnv(override.envVar); ok && value != "" {
+			*override.field = value
+			glog.V(2).Infof("azure: %s is set, overriding the corresponding cloud-config field", override.envVar)
+		}
+	}
+}
+
+// hasAzureCredentials reports whether config carries enough information to authenticate
+// against Azure Resource Manager: managed identity, a client secret, a client
+// certificate, or a federated (workload identity) token.
+func hasAzureCredentials(config *Config) bool {
+	if config.UseManagedIdentityExtension {
+		return true
+	}
+	if len(config.AADClientSecret) > 0 {
+		return true
+	}
+	if len(config.AADClientCertPath) > 0 {
+		return true
+	}
+	if len(config.AADFederatedTokenFile) > 0 {
+		return true
+	}
+	return false
+}
+
+// uuidRegex matches the canonical 8-4-4-4-12 hex representation used by tenantId,
+// subscriptionId, aadClientId, and the *SubscriptionID overrides.
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func isValidUUID(s string) bool {
+	return uuidRegex.MatchString(s)
+}
+
+// validateAzureConfig checks that config carries a complete, self-consistent set of the fields
+// configureClients needs, so a typo'd or incomplete cloud-config fails fast at startup with a
+// field-specific error message rather than surfacing later as an opaque authentication or
+// "resource not found" failure on the first API call.
+func validateAzureConfig(config *Config) error {
+	if config.TenantID == "" {
+		return fmt.Errorf("tenantId is required")
+	}
+	if !isValidUUID(config.TenantID) {
+		return fmt.Errorf("tenantId %q is not a valid UUID", config.TenantID)
+	}
+
+	if config.SubscriptionID == "" {
+		return fmt.Errorf("subscriptionId is required")
+	}
+	if !isValidUUID(config.SubscriptionID) {
+		return fmt.Errorf("subscriptionId %q is not a valid UUID", config.SubscriptionID)
+	}
+
+	if config.ResourceGroup == "" {
+		return fmt.Errorf("resourceGroup is required")
+	}
+
+	for _, override := range []struct {
+		field string
+		value string
+	}{
+		{"vnetSubscriptionID", config.VnetSubscriptionID},
+		{"routeTableSubscriptionID", config.RouteTableSubscriptionID},
+		{"diskSubscriptionID", config.DiskSubscriptionID},
+	} {
+		if override.value != "" && !isValidUUID(override.value) {
+			return fmt.Errorf("%s %q is not a valid UUID", override.field, override.value)
+		}
+	}
+
+	authMethods := 0
+	if config.UseManagedIdentityExtension {
+		authMethods++
+	}
+	if config.AADClientSecret != "" {
+		authMethods++
+	}
+	if config.AADClientCertPath != "" {
+		authMethods++
+	}
+	if config.AADFederatedTokenFile != "" {
+		authMethods++
+	}
+	switch {
+	case authMethods == 0:
+		return fmt.Errorf("no authentication method configured: set useManagedIdentityExtension, aadClientSecret, aadClientCertPath, or aadFederatedTokenFile")
+	case authMethods > 1:
+		return fmt.Errorf("useManagedIdentityExtension, aadClientSecret, aadClientCertPath, and aadFederatedTokenFile are mutually exclusive, but more than one is set")
+	}
+
+	if config.AADClientSecret != "" || config.AADClientCertPath != "" || config.AADFederatedTokenFile != "" {
+		if config.AADClientID == "" {
+			return fmt.Errorf("aadClientId is required when aadClientSecret, aadClientCertPath, or aadFederatedTokenFile is set")
+		}
+		if !isValidUUID(config.AADClientID) {
+			return fmt.Errorf("aadClientId %q is not a valid UUID", config.AADClientID)
+		}
+	}
+
+	if config.StorageAADClientSecret != "" && config.StorageAADClientCertPath != "" {
+		return fmt.Errorf("storageAADClientSecret and storageAADClientCertPath are mutually exclusive, but both are set")
+	}
+	if config.StorageAADClientSecret != "" || config.StorageAADClientCertPath != "" {
+		if config.StorageAADClientID == "" {
+			return fmt.Errorf("storageAADClientID is required when storageAADClientSecret or storageAADClientCertPath is set")
+		}
+		if !isValidUUID(config.StorageAADClientID) {
+			return fmt.Errorf("storageAADClientID %q is not a valid UUID", config.StorageAADClientID)
+		}
+	}
+
+	return nil
+}
+
+// validateEnvironment checks that env carries the endpoint URLs configureClients depends on.
+// This deliberately stops short of actually probing them over the network: a transient DNS or
+// connectivity blip at startup shouldn't be indistinguishable from a genuinely broken config, and
+// every endpoint is dialed for real moments later when the first ARM call goes out.
+func validateEnvironment(env *azure.Environment) error {
+	for _, endpoint := range []struct {
+		field string
+		value string
+	}{
+		{"activeDirectoryEndpoint", env.ActiveDirectoryEndpoint},
+		{"resourceManagerEndpoint", env.ResourceManagerEndpoint},
+		{"serviceManagementEndpoint", env.ServiceManagementEndpoint},
+	} {
+		if endpoint.value == "" {
+			return fmt.Errorf("%s is empty for cloud environment %q", endpoint.field, env.Name)
+		}
+		if _, err := url.Parse(endpoint.value); err != nil {
+			return fmt.Errorf("%s %q for cloud environment %q is not a valid URL: %v", endpoint.field, endpoint.value, env.Name, err)
+		}
+	}
+	return nil
+}
+
 // GetServicePrincipalToken creates a new service principal token based on the configuration
 func GetServicePrincipalToken(config *Config, env *azure.Environment) (*adal.ServicePrincipalToken, error) {
 	oauthConfig, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, config.TenantID)
@@ -165,6 +590,15 @@ func GetServicePrincipalToken(config *Config, env *azure.Environment) (*adal.Ser
 	}
 
 	if config.UseManagedIdentityExtension {
+		if config.UserAssignedIdentityID != "" {
+			// Picking a specific identity's token when the VM carries several needs
+			// adal.NewServicePrincipalTokenFromMSIWithUserAssignedID, which the version of
+			// go-autorest/autorest/adal vendored into this tree doesn't have: its MSI token
+			// source always requests a token for the VM's (sole) identity.
+			return nil, fmt.Errorf("userAssignedIdentityID is set to %q, but selecting a user-assigned identity "+
+				"by client ID requires a newer go-autorest/autorest/adal than the one vendored into this tree; "+
+				"leave userAssignedIdentityID unset and assign the VM a single identity instead", config.UserAssignedIdentityID)
+		}
 		glog.V(2).Infoln("azure: using managed identity extension to retrieve access token")
 		return adal.NewServicePrincipalTokenFromMSI(
 			*oauthConfig,
@@ -180,7 +614,7 @@ func GetServicePrincipalToken(config *Config, env *azure.Environment) (*adal.Ser
 			env.ServiceManagementEndpoint)
 	}
 
-	if len(config.AADClientCertPath) > 0 && len(config.AADClientCertPassword) > 0 {
+	if len(config.AADClientCertPath) > 0 {
 		glog.V(2).Infoln("azure: using jwt client_assertion (client_cert+client_private_key) to retrieve access token")
 		certData, err := ioutil.ReadFile(config.AADClientCertPath)
 		if err != nil {
@@ -198,9 +632,44 @@ func GetServicePrincipalToken(config *Config, env *azure.Environment) (*adal.Ser
 			env.ServiceManagementEndpoint)
 	}
 
+	if len(config.AADFederatedTokenFile) > 0 {
+		glog.V(2).Infoln("azure: using workload identity (federated service account token) to retrieve access token")
+		return adal.NewServicePrincipalTokenWithSecret(
+			*oauthConfig,
+			config.AADClientID,
+			env.ServiceManagementEndpoint,
+			&federatedTokenSecret{tokenFilePath: config.AADFederatedTokenFile})
+	}
+
 	return nil, fmt.Errorf("No credentials provided for AAD application %s", config.AADClientID)
 }
 
+// buildStorageAuthorizer returns primary unchanged when config carries no StorageAADClientID
+// credentials, or a new autorest.Authorizer built from them otherwise. Storage credentials aren't
+// hot-reloaded the way the primary ones are through watchConfigFile: this is a narrower, less
+// frequently rotated identity, and adding a second reloadingAuthorizer here for one client would
+// outweigh the benefit.
+func buildStorageAuthorizer(config *Config, env *azure.Environment, primary autorest.Authorizer) (autorest.Authorizer, error) {
+	if config.StorageAADClientSecret == "" && config.StorageAADClientCertPath == "" {
+		return primary, nil
+	}
+
+	storageConfig := *config
+	storageConfig.AADClientID = config.StorageAADClientID
+	storageConfig.AADClientSecret = config.StorageAADClientSecret
+	storageConfig.AADClientCertPath = config.StorageAADClientCertPath
+	storageConfig.AADClientCertPassword = config.StorageAADClientCertPassword
+	storageConfig.UseManagedIdentityExtension = false
+	storageConfig.AADFederatedTokenFile = ""
+
+	token, err := GetServicePrincipalToken(&storageConfig, env)
+	if err != nil {
+		return nil, fmt.Errorf("building the storage-plane service principal token: %v", err)
+	}
+	glog.V(2).Infoln("azure: using a separate AAD application for storage account operations")
+	return autorest.NewBearerAuthorizer(token), nil
+}
+
 // NewCloud returns a Cloud with initialized clients
 func NewCloud(configReader io.Reader) (cloudprovider.Interface, error) {
 	config, env, err := ParseConfig(configReader)
@@ -211,109 +680,310 @@ func NewCloud(configReader io.Reader) (cloudprovider.Interface, error) {
 		Config:      *config,
 		Environment: *env,
 	}
+	az.metadata = NewInstanceMetadata()
+	az.instanceExistsCache = newInstanceExistsCache()
+
+	if az.CloudConfigSecretName != "" && !hasAzureCredentials(config) {
+		// Nothing more to configure until Initialize supplies a clientset to fetch the secret
+		// with. Components that never call Initialize (e.g. the kubelet) simply never get ARM
+		// clients built this way; that's fine for CloudConfigSecretName's intended use, feeding
+		// credentials to a cloud-controller-manager that never needs them written to node disks.
+		glog.V(2).Infof("azure: cloudConfigSecretName is set and no AAD credentials were configured, deferring client setup to Initialize")
+		return &az, nil
+	}
+
+	if az.UseInstanceMetadata && !hasAzureCredentials(config) {
+		// The kubelet only ever calls NodeAddresses/InstanceID/InstanceType, all of which
+		// are already served from the instance metadata service when UseInstanceMetadata is
+		// set. Skip fetching an AAD token and building ARM clients entirely, so kubelets can
+		// run with a cloud-config that carries no credentials at all; ARM calls stay the
+		// controller-manager's job.
+		glog.V(2).Infof("azure: useInstanceMetadata is set and no AAD credentials were configured, running in instance-metadata-only mode")
+		az.vmSet = newAvailabilitySet(&az)
+		return &az, nil
+	}
+
+	// If configReader came from a real file (the normal --cloud-config path), watch it for
+	// changes so rotated AAD credentials can be picked up without restarting the process. A
+	// configReader that isn't a *os.File (e.g. an in-memory reader built for a test, or a secret
+	// loaded through Initialize) just means hot-reload is skipped.
+	configFilePath := ""
+	if f, ok := configReader.(*os.File); ok {
+		configFilePath = f.Name()
+	}
+
+	if err := az.configureClients(config, env, configFilePath); err != nil {
+		return nil, err
+	}
+	return &az, nil
+}
+
+// configureClients builds an AAD token and every ARM client from config/env, and configures the
+// rate limiter, backoff, vmSet, and disk controllers that depend on them. Shared by NewCloud and
+// by Initialize's CloudConfigSecretName path, which parses a config the same way but only once a
+// clientset is available to fetch the secret with.
+func (az *Cloud) configureClients(config *Config, env *azure.Environment, configFilePath string) error {
+	if err := validateAzureConfig(config); err != nil {
+		return fmt.Errorf("invalid cloud config: %v", err)
+	}
+	if err := validateEnvironment(env); err != nil {
+		return fmt.Errorf("invalid cloud config: %v", err)
+	}
 
 	servicePrincipalToken, err := GetServicePrincipalToken(config, env)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	authorizer := newReloadingAuthorizer(autorest.NewBearerAuthorizer(servicePrincipalToken))
+
+	storageAuthorizer, err := buildStorageAuthorizer(config, env, authorizer)
+	if err != nil {
+		return err
+	}
+
+	if az.HTTPProxy != "" {
+		proxyURL, err := url.Parse(az.HTTPProxy)
+		if err != nil {
+			return fmt.Errorf("invalid httpProxy %q in cloud config: %v", az.HTTPProxy, err)
+		}
+		jar, _ := cookiejar.New(nil)
+		az.httpClient = &http.Client{Jar: jar, Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	}
+
+	baseSender := az.httpClient
+	if baseSender == nil {
+		jar, _ := cookiejar.New(nil)
+		baseSender = &http.Client{Jar: jar}
+	}
+	// correlationIDSender sits right against the transport, ahead of even throttlingSender, so
+	// it logs the correlation/request IDs off of every response ARM sends back, including the
+	// ones a retry never gets to see.
+	correlatedBaseSender := &correlationIDSender{next: baseSender}
+	// az.apiCallCounters is built here, ahead of debugCountingSender below, so the debug
+	// endpoint has something to report on as soon as the first request goes out.
+	az.apiCallCounters = newAPICallCounters()
+	countingBaseSender := &debugCountingSender{next: correlatedBaseSender, counters: az.apiCallCounters}
+	// If audit logging is enabled, auditSender sits right alongside debugCountingSender so it
+	// logs the actual outbound request/response pair for every mutating call, including one
+	// issued as a throttling retry, not just the value the caller ultimately observes.
+	auditedBaseSender := autorest.Sender(countingBaseSender)
+	if az.CloudProviderAuditLogPath != "" {
+		logger, err := newAuditLogger(az.CloudProviderAuditLogPath)
+		if err != nil {
+			return fmt.Errorf("failed to open cloudProviderAuditLogPath %q: %v", az.CloudProviderAuditLogPath, err)
+		}
+		az.auditLogger = logger
+		auditedBaseSender = &auditSender{next: countingBaseSender, logger: logger}
 	}
+	// throttlingSender sits just above the audit/counting/correlation layers (which only observe),
+	// so it observes and retries the real ARM response before any of the layers above (reauth,
+	// api-version pinning, the pre-emptive token-bucket limiter) get a look at it.
+	throttledBaseSender := &throttlingSender{next: auditedBaseSender}
 
-	az.SubnetsClient = network.NewSubnetsClient(az.SubscriptionID)
+	// Every ARM client retries once through reauthSender when a call comes back 401, whether
+	// because the AAD token expired mid-operation or the SP's secret was rotated out from under
+	// it, instead of failing every request until the process restarts.
+	reauthSender := &reauthenticatingSender{next: throttledBaseSender, az: az, authorizer: authorizer}
+
+	// Layered on top of reauthSender so a pinned api-version (needed for clouds, like Azure
+	// Stack, whose control plane lags behind this SDK's default versions) survives a reauth retry
+	// too.
+	computeSender := wrapAPIVersion(reauthSender, az.ComputeAPIVersion)
+	networkSender := wrapAPIVersion(reauthSender, az.NetworkAPIVersion)
+	storageSender := wrapAPIVersion(reauthSender, az.StorageAPIVersion)
+
+	// az.operationPollRateLimiter and its write counterpart are built here, ahead of the
+	// per-client Sender wiring below, so the same limiter that already paces
+	// long-running-operation polling (see azure_wrap.go and friends) can also gate every outbound
+	// compute/network/storage request. Reads and writes are kept on independent token buckets so
+	// a burst of List/Get traffic can't starve a latency-sensitive write like a disk attach PUT.
+	az.operationPollRateLimiter = newCloudProviderRateLimiter(config.CloudProviderRateLimit, &config.CloudProviderRateLimitQPS, &config.CloudProviderRateLimitBucket)
+	if config.CloudProviderRateLimitQPSWrite == 0 {
+		config.CloudProviderRateLimitQPSWrite = config.CloudProviderRateLimitQPS
+	}
+	if config.CloudProviderRateLimitBucketWrite == 0 {
+		config.CloudProviderRateLimitBucketWrite = config.CloudProviderRateLimitBucket
+	}
+	az.operationPollRateLimiterWrite = newCloudProviderRateLimiter(config.CloudProviderRateLimit, &config.CloudProviderRateLimitQPSWrite, &config.CloudProviderRateLimitBucketWrite)
+	if config.CloudProviderRateLimit {
+		glog.V(2).Infof("Azure cloudprovider using rate limit config: read QPS=%g, read bucket=%d, write QPS=%g, write bucket=%d",
+			az.CloudProviderRateLimitQPS,
+			az.CloudProviderRateLimitBucket,
+			az.CloudProviderRateLimitQPSWrite,
+			az.CloudProviderRateLimitBucketWrite)
+	}
+	computeSender = &rateLimitingSender{next: computeSender, readLimiter: az.operationPollRateLimiter, writeLimiter: az.operationPollRateLimiterWrite}
+	networkSender = &rateLimitingSender{next: networkSender, readLimiter: az.operationPollRateLimiter, writeLimiter: az.operationPollRateLimiterWrite}
+	storageSender = &rateLimitingSender{next: storageSender, readLimiter: az.operationPollRateLimiter, writeLimiter: az.operationPollRateLimiterWrite}
+
+	// Circuit breakers sit outermost, ahead of rate limiting, so a client that's already known to
+	// be failing fails fast without spending a token off either token bucket.
+	if config.CloudProviderCircuitBreaker {
+		cooldown := time.Duration(config.CloudProviderCircuitBreakerCooldownSeconds) * time.Second
+		computeSender = newCircuitBreakingSender(computeSender, config.CloudProviderCircuitBreakerFailureThreshold, cooldown)
+		networkSender = newCircuitBreakingSender(networkSender, config.CloudProviderCircuitBreakerFailureThreshold, cooldown)
+		storageSender = newCircuitBreakingSender(storageSender, config.CloudProviderCircuitBreakerFailureThreshold, cooldown)
+	}
+
+	// The VNet, route table, and disk resource groups can live in a subscription other than the
+	// one the VMs are in (e.g. a shared networking subscription managed by a different team);
+	// each falls back to SubscriptionID when its own override isn't set.
+	vnetSubscriptionID := az.VnetSubscriptionID
+	if vnetSubscriptionID == "" {
+		vnetSubscriptionID = az.SubscriptionID
+	}
+	routeTableSubscriptionID := az.RouteTableSubscriptionID
+	if routeTableSubscriptionID == "" {
+		routeTableSubscriptionID = az.SubscriptionID
+	}
+	diskSubscriptionID := az.DiskSubscriptionID
+	if diskSubscriptionID == "" {
+		diskSubscriptionID = az.SubscriptionID
+	}
+
+	az.SubnetsClient = network.NewSubnetsClient(vnetSubscriptionID)
 	az.SubnetsClient.BaseURI = az.Environment.ResourceManagerEndpoint
-	az.SubnetsClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	az.SubnetsClient.Authorizer = authorizer
 	az.SubnetsClient.PollingDelay = 5 * time.Second
-	configureUserAgent(&az.SubnetsClient.Client)
+	configureUserAgent(&az.SubnetsClient.Client, az.ClusterName)
+	az.SubnetsClient.Sender = networkSender
 
-	az.RouteTablesClient = network.NewRouteTablesClient(az.SubscriptionID)
+	az.RouteTablesClient = network.NewRouteTablesClient(routeTableSubscriptionID)
 	az.RouteTablesClient.BaseURI = az.Environment.ResourceManagerEndpoint
-	az.RouteTablesClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	az.RouteTablesClient.Authorizer = authorizer
 	az.RouteTablesClient.PollingDelay = 5 * time.Second
-	configureUserAgent(&az.RouteTablesClient.Client)
+	configureUserAgent(&az.RouteTablesClient.Client, az.ClusterName)
+	az.RouteTablesClient.Sender = networkSender
 
-	az.RoutesClient = network.NewRoutesClient(az.SubscriptionID)
+	az.RoutesClient = network.NewRoutesClient(routeTableSubscriptionID)
 	az.RoutesClient.BaseURI = az.Environment.ResourceManagerEndpoint
-	az.RoutesClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	az.RoutesClient.Authorizer = authorizer
 	az.RoutesClient.PollingDelay = 5 * time.Second
-	configureUserAgent(&az.RoutesClient.Client)
+	configureUserAgent(&az.RoutesClient.Client, az.ClusterName)
+	az.RoutesClient.Sender = networkSender
 
 	az.InterfacesClient = network.NewInterfacesClient(az.SubscriptionID)
 	az.InterfacesClient.BaseURI = az.Environment.ResourceManagerEndpoint
-	az.InterfacesClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	az.InterfacesClient.Authorizer = authorizer
 	az.InterfacesClient.PollingDelay = 5 * time.Second
-	configureUserAgent(&az.InterfacesClient.Client)
+	configureUserAgent(&az.InterfacesClient.Client, az.ClusterName)
+	az.InterfacesClient.Sender = networkSender
 
 	az.LoadBalancerClient = network.NewLoadBalancersClient(az.SubscriptionID)
 	az.LoadBalancerClient.BaseURI = az.Environment.ResourceManagerEndpoint
-	az.LoadBalancerClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	az.LoadBalancerClient.Authorizer = authorizer
 	az.LoadBalancerClient.PollingDelay = 5 * time.Second
-	configureUserAgent(&az.LoadBalancerClient.Client)
+	configureUserAgent(&az.LoadBalancerClient.Client, az.ClusterName)
+	az.LoadBalancerClient.Sender = networkSender
 
 	az.VirtualMachinesClient = compute.NewVirtualMachinesClient(az.SubscriptionID)
 	az.VirtualMachinesClient.BaseURI = az.Environment.ResourceManagerEndpoint
-	az.VirtualMachinesClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	az.VirtualMachinesClient.Authorizer = authorizer
 	az.VirtualMachinesClient.PollingDelay = 5 * time.Second
-	configureUserAgent(&az.VirtualMachinesClient.Client)
+	configureUserAgent(&az.VirtualMachinesClient.Client, az.ClusterName)
+	az.VirtualMachinesClient.Sender = computeSender
+
+	az.VMSSClient = compute.NewVirtualMachineScaleSetsClient(az.SubscriptionID)
+	az.VMSSClient.BaseURI = az.Environment.ResourceManagerEndpoint
+	az.VMSSClient.Authorizer = authorizer
+	az.VMSSClient.PollingDelay = 5 * time.Second
+	configureUserAgent(&az.VMSSClient.Client, az.ClusterName)
+	az.VMSSClient.Sender = computeSender
+
+	az.VMSSVMClient = compute.NewVirtualMachineScaleSetVMsClient(az.SubscriptionID)
+	az.VMSSVMClient.BaseURI = az.Environment.ResourceManagerEndpoint
+	az.VMSSVMClient.Authorizer = authorizer
+	az.VMSSVMClient.PollingDelay = 5 * time.Second
+	configureUserAgent(&az.VMSSVMClient.Client, az.ClusterName)
+	az.VMSSVMClient.Sender = computeSender
 
 	az.PublicIPAddressesClient = network.NewPublicIPAddressesClient(az.SubscriptionID)
 	az.PublicIPAddressesClient.BaseURI = az.Environment.ResourceManagerEndpoint
-	az.PublicIPAddressesClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	az.PublicIPAddressesClient.Authorizer = authorizer
 	az.PublicIPAddressesClient.PollingDelay = 5 * time.Second
-	configureUserAgent(&az.PublicIPAddressesClient.Client)
+	configureUserAgent(&az.PublicIPAddressesClient.Client, az.ClusterName)
+	az.PublicIPAddressesClient.Sender = networkSender
 
 	az.SecurityGroupsClient = network.NewSecurityGroupsClient(az.SubscriptionID)
 	az.SecurityGroupsClient.BaseURI = az.Environment.ResourceManagerEndpoint
-	az.SecurityGroupsClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	az.SecurityGroupsClient.Authorizer = authorizer
 	az.SecurityGroupsClient.PollingDelay = 5 * time.Second
-	configureUserAgent(&az.SecurityGroupsClient.Client)
+	configureUserAgent(&az.SecurityGroupsClient.Client, az.ClusterName)
+	az.SecurityGroupsClient.Sender = networkSender
 
 	az.StorageAccountClient = storage.NewAccountsClientWithBaseURI(az.Environment.ResourceManagerEndpoint, az.SubscriptionID)
-	az.StorageAccountClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
-	configureUserAgent(&az.StorageAccountClient.Client)
-
-	az.DisksClient = disk.NewDisksClientWithBaseURI(az.Environment.ResourceManagerEndpoint, az.SubscriptionID)
-	az.DisksClient.Authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
-	configureUserAgent(&az.DisksClient.Client)
-
-	// Conditionally configure rate limits
-	if az.CloudProviderRateLimit {
-		// Assign rate limit defaults if no configuration was passed in
-		if az.CloudProviderRateLimitQPS == 0 {
-			az.CloudProviderRateLimitQPS = rateLimitQPSDefault
-		}
-		if az.CloudProviderRateLimitBucket == 0 {
-			az.CloudProviderRateLimitBucket = rateLimitBucketDefault
-		}
-		az.operationPollRateLimiter = flowcontrol.NewTokenBucketRateLimiter(
-			az.CloudProviderRateLimitQPS,
-			az.CloudProviderRateLimitBucket)
-		glog.V(2).Infof("Azure cloudprovider using rate limit config: QPS=%d, bucket=%d",
-			az.CloudProviderRateLimitQPS,
-			az.CloudProviderRateLimitBucket)
-	} else {
-		// if rate limits are configured off, az.operationPollRateLimiter.Accept() is a no-op
-		az.operationPollRateLimiter = flowcontrol.NewFakeAlwaysRateLimiter()
-	}
+	az.StorageAccountClient.Authorizer = storageAuthorizer
+	configureUserAgent(&az.StorageAccountClient.Client, az.ClusterName)
+	az.StorageAccountClient.Sender = storageSender
 
-	// Conditionally configure resource request backoff
+	az.DisksClient = disk.NewDisksClientWithBaseURI(az.Environment.ResourceManagerEndpoint, diskSubscriptionID)
+	az.DisksClient.Authorizer = authorizer
+	configureUserAgent(&az.DisksClient.Client, az.ClusterName)
+	az.DisksClient.Sender = computeSender
+
+	az.vmCache = newAzureCache(azureObjectCacheTTL, func(key string) (interface{}, error) {
+		az.operationPollRateLimiter.Accept()
+		glog.V(10).Infof("VirtualMachinesClient.Get(%s): start", key)
+		// Fetched with the InstanceView expand so a single cached GET can also answer
+		// availability-zone/fault-domain lookups (see azure_zones.go's GetZoneByNodeName),
+		// instead of those needing their own separate, uncached VirtualMachinesClient.Get.
+		vm, err := az.VirtualMachinesClient.Get(az.ResourceGroup, key, compute.InstanceView)
+		glog.V(10).Infof("VirtualMachinesClient.Get(%s): end", key)
+		return vm, err
+	})
+	az.nicCache = newAzureCache(azureObjectCacheTTL, func(key string) (interface{}, error) {
+		az.operationPollRateLimiter.Accept()
+		glog.V(10).Infof("InterfacesClient.Get(%s): start", key)
+		nic, err := az.InterfacesClient.Get(az.ResourceGroup, key, "")
+		glog.V(10).Infof("InterfacesClient.Get(%s): end", key)
+		return nic, err
+	})
+	az.lbCache = newAzureCache(azureObjectCacheTTL, func(key string) (interface{}, error) {
+		az.operationPollRateLimiter.Accept()
+		glog.V(10).Infof("LoadBalancerClient.Get(%s): start", key)
+		lb, err := az.LoadBalancerClient.Get(az.ResourceGroup, key, "")
+		glog.V(10).Infof("LoadBalancerClient.Get(%s): end", key)
+		return lb, err
+	})
+	az.nsgCache = newAzureCache(azureObjectCacheTTL, func(key string) (interface{}, error) {
+		az.operationPollRateLimiter.Accept()
+		glog.V(10).Infof("SecurityGroupsClient.Get(%s): start", key)
+		sg, err := az.SecurityGroupsClient.Get(az.ResourceGroup, key, "")
+		glog.V(10).Infof("SecurityGroupsClient.Get(%s): end", key)
+		return sg, err
+	})
+	az.publicIPCache = newAzureCache(azureObjectCacheTTL, func(key string) (interface{}, error) {
+		az.operationPollRateLimiter.Accept()
+		glog.V(10).Infof("PublicIPAddressesClient.Get(%s): start", key)
+		pip, err := az.PublicIPAddressesClient.Get(az.ResourceGroup, key, "")
+		glog.V(10).Infof("PublicIPAddressesClient.Get(%s): end", key)
+		return pip, err
+	})
+
+	// az.resourceRequestBackoff is filled in unconditionally, not just when CloudProviderBackoff
+	// is set, because it also backs the always-on poll-for-provisioning-complete loops in the
+	// disk controllers (see azure_blobDiskController.go, azure_managedDiskController.go), not
+	// just the opt-in retry-on-failure helpers in azure_backoff.go used by the LB reconciler and
+	// route controller, which stay gated on CloudProviderBackoff at their own call sites.
+	if az.CloudProviderBackoffRetries == 0 {
+		az.CloudProviderBackoffRetries = backoffRetriesDefault
+	}
+	if az.CloudProviderBackoffExponent == 0 {
+		az.CloudProviderBackoffExponent = backoffExponentDefault
+	}
+	if az.CloudProviderBackoffDuration == 0 {
+		az.CloudProviderBackoffDuration = backoffDurationDefault
+	}
+	if az.CloudProviderBackoffJitter == 0 {
+		az.CloudProviderBackoffJitter = backoffJitterDefault
+	}
+	az.resourceRequestBackoff = wait.Backoff{
+		Steps:    az.CloudProviderBackoffRetries,
+		Factor:   az.CloudProviderBackoffExponent,
+		Duration: time.Duration(az.CloudProviderBackoffDuration) * time.Second,
+		Jitter:   az.CloudProviderBackoffJitter,
+	}
 	if az.CloudProviderBackoff {
-		// Assign backoff defaults if no configuration was passed in
-		if az.CloudProviderBackoffRetries == 0 {
-			az.CloudProviderBackoffRetries = backoffRetriesDefault
-		}
-		if az.CloudProviderBackoffExponent == 0 {
-			az.CloudProviderBackoffExponent = backoffExponentDefault
-		}
-		if az.CloudProviderBackoffDuration == 0 {
-			az.CloudProviderBackoffDuration = backoffDurationDefault
-		}
-		if az.CloudProviderBackoffJitter == 0 {
-			az.CloudProviderBackoffJitter = backoffJitterDefault
-		}
-		az.resourceRequestBackoff = wait.Backoff{
-			Steps:    az.CloudProviderBackoffRetries,
-			Factor:   az.CloudProviderBackoffExponent,
-			Duration: time.Duration(az.CloudProviderBackoffDuration) * time.Second,
-			Jitter:   az.CloudProviderBackoffJitter,
-		}
 		glog.V(2).Infof("Azure cloudprovider using retry backoff: retries=%d, exponent=%f, duration=%d, jitter=%f",
 			az.CloudProviderBackoffRetries,
 			az.CloudProviderBackoffExponent,
@@ -321,12 +991,29 @@ func NewCloud(configReader io.Reader) (cloudprovider.Interface, error) {
 			az.CloudProviderBackoffJitter)
 	}
 
-	az.metadata = NewInstanceMetadata()
+	if az.CloudProviderMaxConcurrentOperations == 0 {
+		az.CloudProviderMaxConcurrentOperations = maxConcurrentOperationsDefault
+	}
+	az.operationPool = newOperationPool(az.CloudProviderMaxConcurrentOperations)
 
-	if err := initDiskControllers(&az); err != nil {
-		return nil, err
+	switch az.VMType {
+	case vmTypeVMSS:
+		az.vmSet = newScaleSet(az)
+	case vmTypeMixed:
+		az.vmSet = newMixedVMSet(az)
+	case "", vmTypeStandard:
+		az.vmSet = newAvailabilitySet(az)
+	default:
+		return fmt.Errorf("unsupported VMType %q, supported values are %q, %q, and %q", az.VMType, vmTypeStandard, vmTypeVMSS, vmTypeMixed)
 	}
-	return &az, nil
+
+	if err := initDiskControllers(az); err != nil {
+		return err
+	}
+
+	az.watchConfigFile(configFilePath, authorizer)
+
+	return nil
 }
 
 // ParseConfig returns a parsed configuration and azure.Environment for an Azure cloudprovider config file
@@ -347,22 +1034,89 @@ func ParseConfig(configReader io.Reader) (*Config, *azure.Environment, error) {
 		return nil, nil, err
 	}
 
+	applyCredentialEnvironmentOverrides(&config)
+
 	if config.Cloud == "" {
 		env = azure.PublicCloud
 	} else {
 		env, err = azure.EnvironmentFromName(config.Cloud)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, fmt.Errorf("cloud %q in the cloud config is not a recognized Azure environment name "+
+				"(e.g. AzureChinaCloud, AzureGermanCloud, AzureUSGovernmentCloud): %v", config.Cloud, err)
 		}
 	}
 	return &config, &env, nil
 }
 
 // Initialize passes a Kubernetes clientBuilder interface to the cloud provider
-func (az *Cloud) Initialize(clientBuilder controller.ControllerClientBuilder) {}
+func (az *Cloud) Initialize(clientBuilder controller.ControllerClientBuilder) {
+	az.kubeClient = clientBuilder.ClientGoClientOrDie(CloudProviderName)
+
+	az.eventBroadcaster = record.NewBroadcaster()
+	az.eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: v1core.New(az.kubeClient.Core().RESTClient()).Events("")})
+	az.eventRecorder = az.eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: CloudProviderName})
+
+	if az.CloudConfigSecretName != "" && !hasAzureCredentials(&az.Config) {
+		if err := az.configureClientsFromSecret(); err != nil {
+			glog.Errorf("azure: failed to load cloud configuration from secret %s/%s: %v",
+				az.cloudConfigSecretNamespace(), az.CloudConfigSecretName, err)
+		}
+	}
+
+	if err := az.warmVMCache(); err != nil {
+		glog.Errorf("azure: failed to warm VM cache on startup, falling back to per-node GETs: %v", err)
+	}
+
+	az.watchNodeDeletions(
+		[]nodeDeletionHook{az.invalidateInstanceExistsCacheOnDeletion, az.invalidatePowerStateCacheOnDeletion, az.invalidateVMCacheOnDeletion},
+		az.invalidateVMCacheOnUpsert,
+	)
+	if az.PowerStatePollIntervalInSeconds > 0 {
+		az.startPowerStatePoller(time.Duration(az.PowerStatePollIntervalInSeconds) * time.Second)
+	}
+	az.startDebugServer()
+}
+
+// cloudConfigSecretNamespace returns the namespace CloudConfigSecretName should be read from.
+func (az *Cloud) cloudConfigSecretNamespace() string {
+	if az.CloudConfigSecretNamespace != "" {
+		return az.CloudConfigSecretNamespace
+	}
+	return cloudConfigSecretNamespaceDefault
+}
+
+// configureClientsFromSecret reads a full cloud-config file out of the CloudConfigSecretName
+// secret and uses it to build ARM clients, the same way NewCloud would from a --cloud-config
+// file. It's only reachable when NewCloud deferred client setup because no credentials were
+// present in the file passed on the command line.
+func (az *Cloud) configureClientsFromSecret() error {
+	secret, err := az.kubeClient.Core().Secrets(az.cloudConfigSecretNamespace()).Get(az.CloudConfigSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get secret %s/%s: %v", az.cloudConfigSecretNamespace(), az.CloudConfigSecretName, err)
+	}
+
+	configContents, ok := secret.Data[cloudConfigSecretKey]
+	if !ok {
+		return fmt.Errorf("secret %s/%s has no %q key", az.cloudConfigSecretNamespace(), az.CloudConfigSecretName, cloudConfigSecretKey)
+	}
+
+	config, env, err := ParseConfig(bytes.NewReader(configContents))
+	if err != nil {
+		return fmt.Errorf("could not parse cloud configuration from secret %s/%s: %v", az.cloudConfigSecretNamespace(), az.CloudConfigSecretName, err)
+	}
+
+	az.Config = *config
+	az.Environment = *env
+	// The config file backing the secret isn't a local path fsnotify can watch; rotating
+	// credentials means updating the secret and restarting, same as UseManagedIdentityExtension.
+	return az.configureClients(config, env, "")
+}
 
 // LoadBalancer returns a balancer interface. Also returns true if the interface is supported, false otherwise.
 func (az *Cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	if az.DisableLoadBalancer {
+		return nil, false
+	}
 	return az, true
 }
 
@@ -373,6 +1127,9 @@ func (az *Cloud) Instances() (cloudprovider.Instances, bool) {
 
 // Zones returns a zones interface. Also returns true if the interface is supported, false otherwise.
 func (az *Cloud) Zones() (cloudprovider.Zones, bool) {
+	if az.DisableZones {
+		return nil, false
+	}
 	return az, true
 }
 
@@ -383,6 +1140,9 @@ func (az *Cloud) Clusters() (cloudprovider.Clusters, bool) {
 
 // Routes returns a routes interface along with whether the interface is supported.
 func (az *Cloud) Routes() (cloudprovider.Routes, bool) {
+	if az.DisableRoutes {
+		return nil, false
+	}
 	return az, true
 }
 
@@ -392,10 +1152,25 @@ func (az *Cloud) ScrubDNS(nameservers, searches []string) (nsOut, srchOut []stri
 }
 
 // HasClusterID returns true if the cluster has a clusterID
+//
+// Always true, whether or not ClusterName is set. When ClusterName is set, addClusterNameTag
+// stamps it as the clusterNameTagKey tag on every load balancer, public IP, route table, and
+// managed disk this provider creates, so ownership is disambiguated even in a subscription shared
+// by more than one cluster. ClusterName defaults to "", and then no such tag is added.
 func (az *Cloud) HasClusterID() bool {
 	return true
 }
 
+// DiskControllerEnabled reports whether the in-tree azure-disk volume plugin's blob/managed disk
+// controller is available on this Cloud, i.e. DisableDiskController wasn't set in the cloud
+// config. Checked by pkg/volume/azure_dd before casting the cloud provider to its DiskController
+// interface, since that cast otherwise always succeeds (the methods are promoted from embedded
+// fields regardless of DisableDiskController) and calling into a disabled controller would be a
+// confusing way to find out it's off.
+func (az *Cloud) DiskControllerEnabled() bool {
+	return !az.DisableDiskController
+}
+
 // ProviderName returns the cloud provider ID.
 func (az *Cloud) ProviderName() string {
 	return CloudProviderName
@@ -405,9 +1180,59 @@ func (az *Cloud) ProviderName() string {
 // includes "kubernetes" and the full kubernetes git version string
 // example:
 // Azure-SDK-for-Go/7.0.1-beta arm-network/2016-09-01; kubernetes-cloudprovider/v1.7.0-alpha.2.711+a2fadef8170bb0-dirty;
-func configureUserAgent(client *autorest.Client) {
+func configureUserAgent(client *autorest.Client, clusterName string) {
 	k8sVersion := version.Get().GitVersion
 	client.UserAgent = fmt.Sprintf("%s; kubernetes-cloudprovider/%s", client.UserAgent, k8sVersion)
+	if clusterName != "" {
+		client.UserAgent = fmt.Sprintf("%s; cluster/%s", client.UserAgent, clusterName)
+	}
+}
+
+// newCloudProviderRateLimiter builds a token-bucket rate limiter used to pace both
+// long-running-operation polling and, via rateLimitingSender, outbound compute/network/storage
+// ARM requests. It mutates *qps and *bucket in place with the defaults it applied, mirroring the
+// defaulting every other Cloud field gets in configureClients.
+func newCloudProviderRateLimiter(enabled bool, qps *float32, bucket *int) flowcontrol.RateLimiter {
+	if !enabled {
+		// if rate limits are configured off, RateLimiter.Accept() is a no-op
+		return flowcontrol.NewFakeAlwaysRateLimiter()
+	}
+	if *qps == 0 {
+		*qps = rateLimitQPSDefault
+	}
+	if *bucket == 0 {
+		*bucket = rateLimitBucketDefault
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(*qps, *bucket)
+}
+
+// rateLimitingSender throttles every request it forwards, picking readLimiter or writeLimiter
+// based on the request's HTTP method, so a mass reconcile (e.g. relisting every LoadBalancer/
+// NIC/NSG in the cluster after a controller-manager restart) can't starve a latency-sensitive
+// write like a disk attach PUT, or burst past cloudProviderRateLimitQPS and get the whole
+// subscription throttled by Azure.
+type rateLimitingSender struct {
+	next         autorest.Sender
+	readLimiter  flowcontrol.RateLimiter
+	writeLimiter flowcontrol.RateLimiter
+}
+
+func (s *rateLimitingSender) Do(req *http.Request) (*http.Response, error) {
+	if isWriteMethod(req.Method) {
+		s.writeLimiter.Accept()
+	} else {
+		s.readLimiter.Accept()
+	}
+	return s.next.Do(req)
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodPost, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
 }
 
 func initDiskControllers(az *Cloud) error {
@@ -426,6 +1251,7 @@ func initDiskControllers(az *Cloud) error {
 		tokenEndPoint:         az.Environment.ActiveDirectoryEndpoint,
 		subscriptionID:        az.SubscriptionID,
 		cloud:                 az,
+		diskDetachFailures:    make(map[string]time.Time),
 	}
 
 	// BlobDiskController: contains the function needed to
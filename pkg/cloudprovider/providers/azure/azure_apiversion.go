@@ -0,0 +1,49 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// apiVersionOverrideSender rewrites the "api-version" query parameter on every outgoing request
+// to a fixed value. The vendored ARM SDK bakes its api-version into a local const in every
+// generated operation method rather than exposing it as a client field, so there's no supported
+// way to override it short of patching every generated file; doing it at the Sender level, where
+// the request is already fully formed, works against any client without touching generated code.
+type apiVersionOverrideSender struct {
+	next    autorest.Sender
+	version string
+}
+
+// wrapAPIVersion returns next unchanged when version is empty (the SDK's built-in default api-
+// version is used, as before), or a Sender that pins every request through next to version.
+func wrapAPIVersion(next autorest.Sender, version string) autorest.Sender {
+	if version == "" {
+		return next
+	}
+	return &apiVersionOverrideSender{next: next, version: version}
+}
+
+func (s *apiVersionOverrideSender) Do(req *http.Request) (*http.Response, error) {
+	q := req.URL.Query()
+	q.Set("api-version", s.version)
+	req.URL.RawQuery = q.Encode()
+	return s.next.Do(req)
+}
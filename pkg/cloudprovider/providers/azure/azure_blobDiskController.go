@@ -79,6 +79,18 @@ func newBlobDiskController(common *controllerCommon) (*BlobDiskController, error
 	return &c, nil
 }
 
+// accountsSnapshot returns a point-in-time copy of every storage account's cached
+// provisioning state, for the debug endpoint in azure_debug.go.
+func (c *BlobDiskController) accountsSnapshot() map[string]storageAccountState {
+	accountsLock.Lock()
+	defer accountsLock.Unlock()
+	out := make(map[string]storageAccountState, len(c.accounts))
+	for name, state := range c.accounts {
+		out[name] = *state
+	}
+	return out
+}
+
 // CreateVolume creates a VHD blob in a given storage account, will create the given storage account if it does not exist in current resource group
 func (c *BlobDiskController) CreateVolume(name, storageAccount string, storageAccountType storage.SkuName, location string, requestGB int) (string, string, int, error) {
 	key, err := c.common.cloud.getStorageAccesskey(storageAccount)
@@ -91,8 +103,10 @@ func (c *BlobDiskController) CreateVolume(name, storageAccount string, storageAc
 			Location: &location}
 		cancel := make(chan struct{})
 
+		c.common.cloud.operationPool.acquire()
 		_, errchan := c.common.cloud.StorageAccountClient.Create(c.common.resourceGroup, storageAccount, cp, cancel)
 		err = <-errchan
+		c.common.cloud.operationPool.release()
 		if err != nil {
 			return "", "", 0, fmt.Errorf(fmt.Sprintf("Create Storage Account %s, error: %s", storageAccount, err))
 		}
@@ -110,6 +124,12 @@ func (c *BlobDiskController) CreateVolume(name, storageAccount string, storageAc
 	if err != nil {
 		return "", "", 0, err
 	}
+	if c.common.cloud.httpClient != nil {
+		client.HTTPClient = c.common.cloud.httpClient
+	}
+	if c.common.cloud.ClusterName != "" {
+		client.AddToUserAgent(fmt.Sprintf("cluster/%s", c.common.cloud.ClusterName))
+	}
 	blobClient := client.GetBlobService()
 
 	container := blobClient.GetContainerReference(vhdContainerName)
@@ -191,6 +211,7 @@ func (c *BlobDiskController) createVHDBlobDisk(blobClient azstorage.BlobStorageC
 	blob.Metadata = tags
 	err = blob.PutPageBlob(nil)
 	if err != nil {
+		observeStorageThrottled(err)
 		return "", "", fmt.Errorf("failed to put page blob %s in container %s: %v", vhdName, containerName, err)
 	}
 
@@ -206,6 +227,7 @@ func (c *BlobDiskController) createVHDBlobDisk(blobClient azstorage.BlobStorageC
 		End:   uint64(vhdSize - 1),
 	}
 	if err = blob.WriteRange(blobRange, bytes.NewBuffer(h[:vhd.VHD_HEADER_SIZE]), nil); err != nil {
+		observeStorageThrottled(err)
 		glog.Infof("azureDisk - failed to put header page for data disk %s in container %s account %s, error was %s\n",
 			vhdName, containerName, accountName, err.Error())
 		return "", "", err
@@ -221,12 +243,45 @@ func (c *BlobDiskController) createVHDBlobDisk(blobClient azstorage.BlobStorageC
 	return vhdName, uri, nil
 }
 
+// breakBlobLease force-releases the write lease on a VHD-backed disk's page blob, for use when the
+// node that had it attached went away (or stopped responding to ARM) without cleanly detaching and
+// releasing the lease itself. Managed disks have no equivalent to break - ARM owns attach/detach
+// for those directly - so for a managed disk URI this returns the URI parse error.
+func (c *BlobDiskController) breakBlobLease(diskURI string) error {
+	accountName, blobName, err := c.getBlobNameAndAccountFromURI(diskURI)
+	if err != nil {
+		return err
+	}
+	accountKey, err := c.getStorageAccountKey(accountName)
+	if err != nil {
+		return err
+	}
+	client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, accountKey, c.common.cloud.Environment)
+	if err != nil {
+		return err
+	}
+	if c.common.cloud.httpClient != nil {
+		client.HTTPClient = c.common.cloud.httpClient
+	}
+	blobSvc := client.GetBlobService()
+	container := blobSvc.GetContainerReference(vhdContainerName)
+	blob := container.GetBlobReference(blobName)
+	_, err = blob.BreakLease(nil)
+	return err
+}
+
 // delete a vhd blob
 func (c *BlobDiskController) deleteVhdBlob(accountName, accountKey, blobName string) error {
 	client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, accountKey, c.common.cloud.Environment)
 	if err != nil {
 		return err
 	}
+	if c.common.cloud.httpClient != nil {
+		client.HTTPClient = c.common.cloud.httpClient
+	}
+	if c.common.cloud.ClusterName != "" {
+		client.AddToUserAgent(fmt.Sprintf("cluster/%s", c.common.cloud.ClusterName))
+	}
 	blobSvc := client.GetBlobService()
 
 	container := blobSvc.GetContainerReference(vhdContainerName)
@@ -236,7 +291,8 @@ func (c *BlobDiskController) deleteVhdBlob(accountName, accountKey, blobName str
 
 //CreateBlobDisk : create a blob disk in a node
 func (c *BlobDiskController) CreateBlobDisk(dataDiskName string, storageAccountType storage.SkuName, sizeGB int, forceStandAlone bool) (string, error) {
-	glog.V(4).Infof("azureDisk - creating blob data disk named:%s on StorageAccountType:%s StandAlone:%v", dataDiskName, storageAccountType, forceStandAlone)
+	logger := forOperation("CreateBlobDisk")
+	logger.V(4, "creating blob data disk named %s on StorageAccountType %s StandAlone:%v", dataDiskName, storageAccountType, forceStandAlone)
 
 	var storageAccountName = ""
 	var err error
@@ -264,16 +320,19 @@ func (c *BlobDiskController) CreateBlobDisk(dataDiskName string, storageAccountT
 	if err != nil {
 		return "", err
 	}
+	logger = logger.withDiskURI(diskURI)
 
 	if !forceStandAlone {
 		atomic.AddInt32(&c.accounts[storageAccountName].diskCount, 1)
 	}
 
+	logger.V(4, "created blob data disk on storage account %s", storageAccountName)
 	return diskURI, nil
 }
 
 //DeleteBlobDisk : delete a blob disk from a node
 func (c *BlobDiskController) DeleteBlobDisk(diskURI string, wasForced bool) error {
+	logger := forOperation("DeleteBlobDisk").withDiskURI(diskURI)
 	storageAccountName, vhdName, err := diskNameandSANameFromURI(diskURI)
 	if err != nil {
 		return err
@@ -282,7 +341,7 @@ func (c *BlobDiskController) DeleteBlobDisk(diskURI string, wasForced bool) erro
 	_, ok := c.accounts[storageAccountName]
 	if !ok {
 		// the storage account is specified by user
-		glog.V(4).Infof("azureDisk - deleting volume %s", diskURI)
+		logger.V(4, "deleting volume %s", diskURI)
 		return c.DeleteVolume(diskURI)
 	}
 	// if forced (as in one disk = one storage account)
@@ -296,7 +355,7 @@ func (c *BlobDiskController) DeleteBlobDisk(diskURI string, wasForced bool) erro
 		return err
 	}
 
-	glog.V(4).Infof("azureDisk - About to delete vhd file %s on storage account %s container %s", vhdName, storageAccountName, defaultContainerName)
+	logger.V(4, "about to delete vhd file %s on storage account %s container %s", vhdName, storageAccountName, defaultContainerName)
 
 	container := blobSvc.GetContainerReference(defaultContainerName)
 	blob := container.GetBlobReference(vhdName)
@@ -306,7 +365,7 @@ func (c *BlobDiskController) DeleteBlobDisk(diskURI string, wasForced bool) erro
 		if diskCount, err := c.getDiskCount(storageAccountName); err != nil {
 			c.accounts[storageAccountName].diskCount = int32(diskCount)
 		} else {
-			glog.Warningf("azureDisk - failed to get disk count for %s however the delete disk operation was ok", storageAccountName)
+			logger.Warningf("failed to get disk count for %s however the delete disk operation was ok", storageAccountName)
 			return nil // we have failed to aquire a new count. not an error condition
 		}
 	}
@@ -316,6 +375,12 @@ func (c *BlobDiskController) DeleteBlobDisk(diskURI string, wasForced bool) erro
 
 // Init tries best effort to ensure that 2 accounts standard/premium were created
 // to be used by shared blob disks. This to increase the speed pvc provisioning (in most of cases)
+// init runs at Cloud construction time, before leader election - in an HA out-of-process
+// cloud-controller-manager deployment, every replica calls this, not just the elected leader.
+// That's safe here only because the work it schedules (below) is opportunistic and idempotent:
+// account creation is tolerant of failing outright (a losing race with another replica, or with
+// the eventual on-demand creation in CreateVolume, is treated as a no-op, not an error), and
+// nothing here blocks Initialize or requires exclusive ownership of any Azure resource.
 func (c *BlobDiskController) init() error {
 	if !c.shouldInit() {
 		return nil
@@ -419,6 +484,12 @@ func (c *BlobDiskController) getBlobSvcClient(SAName string) (azstorage.BlobStor
 	if client, err = azstorage.NewBasicClientOnSovereignCloud(SAName, key, c.common.cloud.Environment); err != nil {
 		return blobSvc, err
 	}
+	if c.common.cloud.httpClient != nil {
+		client.HTTPClient = c.common.cloud.httpClient
+	}
+	if c.common.cloud.ClusterName != "" {
+		client.AddToUserAgent(fmt.Sprintf("cluster/%s", c.common.cloud.ClusterName))
+	}
 
 	blobSvc = client.GetBlobService()
 	return blobSvc, nil
@@ -466,7 +537,7 @@ func (c *BlobDiskController) ensureDefaultContainer(storageAccountName string) e
 			return nil
 		}
 
-		err = kwait.ExponentialBackoff(defaultBackOff, func() (bool, error) {
+		err = kwait.ExponentialBackoff(c.common.cloud.resourceRequestBackoff, func() (bool, error) {
 			_, provisionState, err := c.getStorageAccountState(storageAccountName)
 
 			if err != nil {
@@ -607,8 +678,10 @@ func (c *BlobDiskController) createStorageAccount(storageAccountName string, sto
 			Location: &location}
 		cancel := make(chan struct{})
 
+		c.common.cloud.operationPool.acquire()
 		_, errChan := c.common.cloud.StorageAccountClient.Create(c.common.resourceGroup, storageAccountName, cp, cancel)
 		err := <-errChan
+		c.common.cloud.operationPool.release()
 		if err != nil {
 			return fmt.Errorf(fmt.Sprintf("Create Storage Account: %s, error: %s", storageAccountName, err))
 		}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"sync"
+	"time"
+)
+
+// azureCacheGetFunc fetches a fresh value for key when an azureCache has no unexpired entry
+// for it. Its error is returned to the caller of azureCache.get unchanged and never cached, so
+// e.g. a 404 DetailedError from checkResourceExistsFromError's caller is retried on every call
+// rather than being remembered as a permanent miss.
+type azureCacheGetFunc func(key string) (interface{}, error)
+
+// azureCache is a get-or-fetch cache with a single TTL and explicit invalidation, shared by the
+// VM, NIC, LoadBalancer, SecurityGroup, and PublicIPAddress lookups in azure_wrap.go to cut down
+// on redundant GETs against ARM when a reconciliation storm (e.g. many Services updating at
+// once) has several controller loops asking about the same object within the same few seconds.
+type azureCache struct {
+	ttl    time.Duration
+	getter azureCacheGetFunc
+
+	mutex   sync.Mutex
+	entries map[string]azureCacheEntry
+}
+
+type azureCacheEntry struct {
+	data    interface{}
+	expires time.Time
+}
+
+func newAzureCache(ttl time.Duration, getter azureCacheGetFunc) *azureCache {
+	return &azureCache{ttl: ttl, getter: getter, entries: map[string]azureCacheEntry{}}
+}
+
+// get returns the cached value for key, calling the cache's getter to fetch and cache it first
+// if there's no unexpired entry.
+func (c *azureCache) get(key string) (interface{}, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.data, nil
+	}
+
+	data, err := c.getter(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.entries[key] = azureCacheEntry{data: data, expires: time.Now().Add(c.ttl)}
+	c.mutex.Unlock()
+	return data, nil
+}
+
+// set seeds the cache with a value already fetched by some other means (e.g. a bulk List used to
+// warm the cache), as if get had just fetched and cached it itself.
+func (c *azureCache) set(key string, data interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = azureCacheEntry{data: data, expires: time.Now().Add(c.ttl)}
+}
+
+// invalidate drops the cached entry for key, if any, so the next get re-fetches instead of
+// returning a value known to be stale (e.g. right after this process itself updated the object).
+func (c *azureCache) invalidate(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, key)
+}
+
+// size returns the number of entries currently cached, unexpired or not, for the debug
+// endpoint in azure_debug.go.
+func (c *azureCache) size() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAzureCacheGetFetchesOnceAndReusesUnexpiredEntry(t *testing.T) {
+	calls := 0
+	c := newAzureCache(time.Minute, func(key string) (interface{}, error) {
+		calls++
+		return fmt.Sprintf("value-for-%s", key), nil
+	})
+
+	for i := 0; i < 3; i++ {
+		v, err := c.get("foo")
+		if err != nil {
+			t.Fatalf("get %d: unexpected error: %v", i, err)
+		}
+		if v.(string) != "value-for-foo" {
+			t.Errorf("get %d: got %q, want %q", i, v, "value-for-foo")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d getter calls, want 1 (later gets should hit the cache)", calls)
+	}
+}
+
+func TestAzureCacheGetRefetchesAfterTTLExpires(t *testing.T) {
+	calls := 0
+	c := newAzureCache(time.Millisecond, func(key string) (interface{}, error) {
+		calls++
+		return calls, nil
+	})
+
+	if _, err := c.get("foo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	v, err := c.get("foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.(int) != 2 {
+		t.Errorf("got %d, want 2 (expired entry should have been refetched)", v)
+	}
+	if calls != 2 {
+		t.Errorf("got %d getter calls, want 2", calls)
+	}
+}
+
+func TestAzureCacheGetDoesNotCacheGetterError(t *testing.T) {
+	calls := 0
+	c := newAzureCache(time.Minute, func(key string) (interface{}, error) {
+		calls++
+		return nil, fmt.Errorf("not found")
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.get("foo"); err == nil {
+			t.Fatalf("get %d: expected error, got nil", i)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d getter calls, want 2 (a getter error must not be cached)", calls)
+	}
+}
+
+func TestAzureCacheSetSeedsCacheWithoutCallingGetter(t *testing.T) {
+	calls := 0
+	c := newAzureCache(time.Minute, func(key string) (interface{}, error) {
+		calls++
+		return "from-getter", nil
+	})
+
+	c.set("foo", "seeded")
+	v, err := c.get("foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.(string) != "seeded" {
+		t.Errorf("got %q, want %q", v, "seeded")
+	}
+	if calls != 0 {
+		t.Errorf("got %d getter calls, want 0 (set should have seeded the entry)", calls)
+	}
+	if c.size() != 1 {
+		t.Errorf("got size %d, want 1", c.size())
+	}
+}
+
+func TestAzureCacheInvalidateForcesRefetch(t *testing.T) {
+	calls := 0
+	c := newAzureCache(time.Minute, func(key string) (interface{}, error) {
+		calls++
+		return calls, nil
+	})
+
+	if _, err := c.get("foo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.invalidate("foo")
+	v, err := c.get("foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.(int) != 2 {
+		t.Errorf("got %d, want 2 (invalidated entry should have been refetched)", v)
+	}
+
+	c.invalidate("bar")
+	if c.size() != 1 {
+		t.Errorf("got size %d, want 1 (invalidating a missing key should be a no-op)", c.size())
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/golang/glog"
+)
+
+const (
+	circuitBreakerFailureThresholdDefault = 5
+	circuitBreakerCooldownDefault         = 30 * time.Second
+)
+
+// errCircuitOpen is returned by circuitBreakingSender instead of calling through to Azure once
+// its failure threshold has been reached, so callers fail fast with a distinct, recognizable
+// error instead of piling up goroutines blocked on a client that's already known to be failing.
+var errCircuitOpen = fmt.Errorf("azure: circuit breaker open for this client, failing fast until the cool-down period elapses")
+
+// circuitBreakingSender opens a fail-fast circuit for one client (compute, network, or storage)
+// after failureThreshold consecutive failed requests - a transport error or a 5xx status - and
+// keeps it open for cooldown before letting another request through to probe recovery. There's
+// one of these per client group rather than per Azure region: requests to a given ARM client in
+// this codebase aren't partitioned by region, so region-scoped breaking isn't meaningful here.
+type circuitBreakingSender struct {
+	next             autorest.Sender
+	failureThreshold int
+	cooldown         time.Duration
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	openUntil           time.Time
+}
+
+func newCircuitBreakingSender(next autorest.Sender, failureThreshold int, cooldown time.Duration) *circuitBreakingSender {
+	if failureThreshold <= 0 {
+		failureThreshold = circuitBreakerFailureThresholdDefault
+	}
+	if cooldown <= 0 {
+		cooldown = circuitBreakerCooldownDefault
+	}
+	return &circuitBreakingSender{next: next, failureThreshold: failureThreshold, cooldown: cooldown}
+}
+
+func (s *circuitBreakingSender) Do(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	if !s.openUntil.IsZero() && time.Now().Before(s.openUntil) {
+		s.mu.Unlock()
+		return nil, errCircuitOpen
+	}
+	s.mu.Unlock()
+
+	resp, err := s.next.Do(req)
+	s.record(resp, err)
+	return resp, err
+}
+
+func (s *circuitBreakingSender) record(resp *http.Response, err error) {
+	failed := err != nil || (resp != nil && resp.StatusCode >= http.StatusInternalServerError)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !failed {
+		s.consecutiveFailures = 0
+		s.openUntil = time.Time{}
+		return
+	}
+	s.consecutiveFailures++
+	if s.consecutiveFailures >= s.failureThreshold {
+		s.openUntil = time.Now().Add(s.cooldown)
+		glog.Warningf("azure: %d consecutive failures on this client, opening circuit breaker for %s", s.consecutiveFailures, s.cooldown)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeResult is a single (response, error) pair for fakeSender to hand back.
+type fakeResult struct {
+	resp *http.Response
+	err  error
+}
+
+// fakeSender is a minimal autorest.Sender stub that returns a queue of results, one per
+// call, so a circuitBreakingSender test can script a sequence of successes/failures
+// without a real HTTP round trip. Once the queue is drained, further calls return the
+// last result again, so tests only need to queue up as many results as they check.
+type fakeSender struct {
+	results []fakeResult
+	calls   int
+}
+
+func (f *fakeSender) Do(req *http.Request) (*http.Response, error) {
+	i := f.calls
+	f.calls++
+	if i >= len(f.results) {
+		i = len(f.results) - 1
+	}
+	if i < 0 {
+		return nil, nil
+	}
+	return f.results[i].resp, f.results[i].err
+}
+
+func (f *fakeSender) queue(resp *http.Response, err error) {
+	f.results = append(f.results, fakeResult{resp: resp, err: err})
+}
+
+func statusResponse(code int) *http.Response {
+	return &http.Response{StatusCode: code}
+}
+
+func TestCircuitBreakingSenderPassesThroughSuccess(t *testing.T) {
+	next := &fakeSender{}
+	next.queue(statusResponse(http.StatusOK), nil)
+	s := newCircuitBreakingSender(next, 3, time.Minute)
+
+	resp, err := s.Do(&http.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if next.calls != 1 {
+		t.Errorf("got %d calls to next, want 1", next.calls)
+	}
+}
+
+func TestCircuitBreakingSenderOpensAfterConsecutiveFailures(t *testing.T) {
+	next := &fakeSender{}
+	next.queue(nil, fmt.Errorf("transport error 1"))
+	next.queue(nil, fmt.Errorf("transport error 2"))
+	next.queue(nil, fmt.Errorf("transport error 3"))
+	s := newCircuitBreakingSender(next, 3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Do(&http.Request{}); err == nil {
+			t.Fatalf("call %d: expected the underlying transport error, got nil", i)
+		}
+	}
+
+	// The circuit should now be open: the fourth call must fail fast with errCircuitOpen
+	// and must not reach next.Do at all.
+	if _, err := s.Do(&http.Request{}); err != errCircuitOpen {
+		t.Errorf("got err %v, want errCircuitOpen", err)
+	}
+	if next.calls != 3 {
+		t.Errorf("got %d calls to next after circuit opened, want 3 (no call while open)", next.calls)
+	}
+}
+
+func TestCircuitBreakingSenderTreatsServerErrorsAsFailures(t *testing.T) {
+	next := &fakeSender{}
+	next.queue(statusResponse(http.StatusInternalServerError), nil)
+	next.queue(statusResponse(http.StatusBadGateway), nil)
+	s := newCircuitBreakingSender(next, 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Do(&http.Request{}); err != nil {
+			t.Fatalf("call %d: unexpected error from fake sender: %v", i, err)
+		}
+	}
+
+	if _, err := s.Do(&http.Request{}); err != errCircuitOpen {
+		t.Errorf("got err %v, want errCircuitOpen after two consecutive 5xx responses", err)
+	}
+}
+
+func TestCircuitBreakingSenderClosesAfterCooldown(t *testing.T) {
+	next := &fakeSender{}
+	next.queue(nil, fmt.Errorf("transport error 1"))
+	next.queue(nil, fmt.Errorf("transport error 2"))
+	s := newCircuitBreakingSender(next, 2, time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Do(&http.Request{}); err == nil {
+			t.Fatalf("call %d: expected the underlying transport error, got nil", i)
+		}
+	}
+
+	if _, err := s.Do(&http.Request{}); err != errCircuitOpen {
+		t.Fatalf("got err %v, want errCircuitOpen immediately after opening", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	next.queue(statusResponse(http.StatusOK), nil)
+	resp, err := s.Do(&http.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error after cooldown elapsed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestCircuitBreakingSenderSuccessResetsFailureCount(t *testing.T) {
+	next := &fakeSender{}
+	next.queue(nil, fmt.Errorf("transport error 1"))
+	next.queue(statusResponse(http.StatusOK), nil)
+	next.queue(nil, fmt.Errorf("transport error 2"))
+	s := newCircuitBreakingSender(next, 2, time.Minute)
+
+	if _, err := s.Do(&http.Request{}); err == nil {
+		t.Fatal("call 1: expected the underlying transport error, got nil")
+	}
+	if _, err := s.Do(&http.Request{}); err != nil {
+		t.Fatalf("call 2: unexpected error: %v", err)
+	}
+	// The single intervening success should have reset the streak, so this second
+	// consecutive failure alone must not be enough to open the circuit.
+	if _, err := s.Do(&http.Request{}); err == nil || err == errCircuitOpen {
+		t.Fatalf("call 3: got err %v, want the underlying transport error (circuit should still be closed)", err)
+	}
+}
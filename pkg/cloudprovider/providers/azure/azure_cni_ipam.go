@@ -0,0 +1,66 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/golang/glog"
+)
+
+// NodeSubnetCIDR returns the address prefix of the cluster's configured VNet subnet, for
+// use by the node-ipam-controller as an alternative to a fixed --node-cidr-mask-size when
+// running Azure CNI. Under Azure CNI, pod IPs are allocated directly out of this subnet by
+// the CNI IPAM plugin rather than routed via per-node UDRs, so the node controller's usual
+// PodCIDR-based mask sizing doesn't apply; callers wiring up CNI clusters can use the
+// returned prefix to size each node's slice of the subnet instead of guessing.
+//
+// NOTE: the vendored cloudprovider.Routes/Instances interfaces have no hook for a cloud
+// provider to influence node-ipam-controller's mask sizing directly, so this is exposed as
+// a plain Cloud method for such a caller to invoke explicitly rather than wired into an
+// interface.
+func (az *Cloud) NodeSubnetCIDR() (*net.IPNet, error) {
+	az.operationPollRateLimiter.Accept()
+	glog.V(10).Infof("SubnetsClient.Get(%s,%s): start", az.VnetName, az.SubnetName)
+	subnet, err := az.SubnetsClient.Get(az.ResourceGroup, az.VnetName, az.SubnetName, "")
+	glog.V(10).Infof("SubnetsClient.Get(%s,%s): end", az.VnetName, az.SubnetName)
+	if err != nil {
+		return nil, err
+	}
+	if subnet.SubnetPropertiesFormat == nil || subnet.AddressPrefix == nil {
+		return nil, fmt.Errorf("subnet %q has no address prefix", az.SubnetName)
+	}
+
+	_, cidr, err := net.ParseCIDR(*subnet.AddressPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("subnet %q has an unparsable address prefix %q: %v", az.SubnetName, *subnet.AddressPrefix, err)
+	}
+	return cidr, nil
+}
+
+// NodeSubnetCIDRMaskSize returns the size, in bits, of the cluster's VNet subnet mask, for
+// callers sizing per-node pod CIDR allocations relative to the whole subnet. See
+// NodeSubnetCIDR for the Azure CNI context this supports.
+func (az *Cloud) NodeSubnetCIDRMaskSize() (int, error) {
+	cidr, err := az.NodeSubnetCIDR()
+	if err != nil {
+		return 0, err
+	}
+	ones, _ := cidr.Mask.Size()
+	return ones, nil
+}
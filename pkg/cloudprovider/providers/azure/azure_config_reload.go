@@ -0,0 +1,140 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// reloadingAuthorizer is an autorest.Authorizer that forwards every request to whichever
+// Authorizer was most recently set with setInner. Every ARM client shares a single instance of
+// this, so rotating credentials replaces one Authorizer instead of rebuilding every client.
+type reloadingAuthorizer struct {
+	mu    sync.RWMutex
+	inner autorest.Authorizer
+}
+
+func newReloadingAuthorizer(initial autorest.Authorizer) *reloadingAuthorizer {
+	return &reloadingAuthorizer{inner: initial}
+}
+
+func (r *reloadingAuthorizer) setInner(a autorest.Authorizer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inner = a
+}
+
+// WithAuthorization implements autorest.Authorizer. It resolves the current inner Authorizer on
+// every request rather than when the decorator chain is built, so a credential rotation takes
+// effect on the next API call rather than only on newly-constructed clients.
+func (r *reloadingAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(req *http.Request) (*http.Request, error) {
+			r.mu.RLock()
+			inner := r.inner
+			r.mu.RUnlock()
+			return inner.WithAuthorization()(p).Prepare(req)
+		})
+	}
+}
+
+// watchConfigFile starts a background watch of configFilePath (if non-empty) and refreshes az's
+// ARM credentials whenever the file's contents change, so rotating AAD credentials in the
+// cloud-config file/ConfigMap doesn't require restarting the process. It watches the file's
+// parent directory rather than the file itself because ConfigMap volumes are updated by
+// atomically re-pointing a symlink, which most filesystem watchers don't see as an event on the
+// target file.
+//
+// Only the credential-derived Authorizer is hot-swapped this way. Other settings in the file
+// (subscriptionId, resourceGroup, vmType, rate limits, backoff, ...) are read from az.Config
+// without synchronization all over this package, and safely hot-reloading them would need a
+// broader concurrency pass than this refresh mechanism attempts; changing those still requires a
+// restart.
+func (az *Cloud) watchConfigFile(configFilePath string, authorizer *reloadingAuthorizer) {
+	if configFilePath == "" {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Errorf("azure: failed to start a watcher on %s, credential rotation will require a restart: %v", configFilePath, err)
+		return
+	}
+
+	watchDir := filepath.Dir(configFilePath)
+	if err := watcher.Add(watchDir); err != nil {
+		glog.Errorf("azure: failed to watch %s, credential rotation will require a restart: %v", watchDir, err)
+		watcher.Close()
+		return
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(configFilePath) {
+					continue
+				}
+				glog.V(2).Infof("azure: cloud config %s changed, reloading credentials", configFilePath)
+				az.reloadCredentials(configFilePath, authorizer)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				glog.Errorf("azure: error watching %s: %v", configFilePath, err)
+			}
+		}
+	}()
+}
+
+// reloadCredentials re-parses configFilePath and, if it still describes valid credentials, swaps
+// them into authorizer. It leaves the currently active credentials in place on any error, so a
+// bad or partially-written config file doesn't take down a running control plane.
+func (az *Cloud) reloadCredentials(configFilePath string, authorizer *reloadingAuthorizer) {
+	f, err := os.Open(configFilePath)
+	if err != nil {
+		glog.Errorf("azure: failed to open %s for credential reload: %v", configFilePath, err)
+		return
+	}
+	defer f.Close()
+
+	config, env, err := ParseConfig(f)
+	if err != nil {
+		glog.Errorf("azure: failed to parse %s for credential reload: %v", configFilePath, err)
+		return
+	}
+
+	token, err := GetServicePrincipalToken(config, env)
+	if err != nil {
+		glog.Errorf("azure: failed to build a service principal token from the reloaded config, keeping the previous credentials: %v", err)
+		return
+	}
+
+	authorizer.setInner(autorest.NewBearerAuthorizer(token))
+	glog.V(2).Infof("azure: reloaded ARM credentials from %s", configFilePath)
+}
@@ -18,14 +18,17 @@ package azure
 
 import (
 	"fmt"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
+	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
-	kwait "k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/kubernetes/pkg/cloudprovider"
 
 	"github.com/Azure/azure-sdk-for-go/arm/compute"
+	azdisk "github.com/Azure/azure-sdk-for-go/arm/disk"
 	"github.com/golang/glog"
 )
 
@@ -45,13 +48,6 @@ const (
 	errContainerNotFound = "ContainerNotFound"
 )
 
-var defaultBackOff = kwait.Backoff{
-	Steps:    20,
-	Duration: 2 * time.Second,
-	Factor:   1.5,
-	Jitter:   0.0,
-}
-
 type controllerCommon struct {
 	tenantID              string
 	subscriptionID        string
@@ -66,11 +62,18 @@ type controllerCommon struct {
 	aadToken              string
 	expiresOn             time.Time
 	cloud                 *Cloud
+
+	// diskDetachFailuresLock guards diskDetachFailures, which records the time each disk URI
+	// first failed to detach. Consulted by DetachDiskByName to decide when DetachDiskForceTimeoutMinutes
+	// has elapsed and a stuck detach should be forced through instead of retried again.
+	diskDetachFailuresLock sync.Mutex
+	diskDetachFailures     map[string]time.Time
 }
 
 // AttachDisk attaches a vhd to vm
 // the vhd must exist, can be identified by diskName, diskURI, and lun.
 func (c *controllerCommon) AttachDisk(isManagedDisk bool, diskName, diskURI string, nodeName types.NodeName, lun int32, cachingMode compute.CachingTypes) error {
+	logger := forOperation("AttachDisk").withNode(string(nodeName)).withDiskURI(diskURI)
 	vm, exists, err := c.cloud.getVirtualMachine(nodeName)
 	if err != nil {
 		return err
@@ -110,41 +113,95 @@ func (c *controllerCommon) AttachDisk(isManagedDisk bool, diskName, diskURI stri
 			},
 		},
 	}
-	vmName := mapNodeNameToVMName(nodeName)
-	glog.V(2).Infof("azureDisk - update(%s): vm(%s) - attach disk", c.resourceGroup, vmName)
+	vmName := c.cloud.mapNodeNameToVMName(nodeName)
+	logger.V(2, "updating vm(%s) to attach disk", vmName)
 	c.cloud.operationPollRateLimiter.Accept()
+	c.cloud.operationPool.acquire()
 	respChan, errChan := c.cloud.VirtualMachinesClient.CreateOrUpdate(c.resourceGroup, vmName, newVM, nil)
 	resp := <-respChan
 	err = <-errChan
+	c.cloud.operationPool.release()
+	c.cloud.vmCache.invalidate(vmName)
 	if c.cloud.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
-		glog.V(2).Infof("azureDisk - update(%s) backing off: vm(%s)", c.resourceGroup, vmName)
+		logger.V(2, "backing off update of vm(%s)", vmName)
 		retryErr := c.cloud.CreateOrUpdateVMWithRetry(vmName, newVM)
 		if retryErr != nil {
 			err = retryErr
-			glog.V(2).Infof("azureDisk - update(%s) abort backoff: vm(%s)", c.resourceGroup, vmName)
+			logger.V(2, "abort backoff for update of vm(%s)", vmName)
 		}
 	}
 	if err != nil {
-		glog.Errorf("azureDisk - azure attach failed, err: %v", err)
+		logger.Errorf("azure attach failed: %v", err)
 		detail := err.Error()
 		if strings.Contains(detail, errLeaseFailed) {
 			// if lease cannot be acquired, immediately detach the disk and return the original error
-			glog.Infof("azureDisk - failed to acquire disk lease, try detach")
+			logger.V(2, "failed to acquire disk lease, trying detach")
 			c.cloud.DetachDiskByName(diskName, diskURI, nodeName)
 		}
 	} else {
-		glog.V(4).Infof("azureDisk - azure attach succeeded")
+		logger.V(4, "azure attach succeeded")
 	}
 	return err
 }
 
+// shouldForceDetach reports whether diskURI has been failing DetachDiskByName for longer than
+// DetachDiskForceTimeoutMinutes, in which case the caller should stop retrying the normal way and
+// force the detach through instead. Also records diskURI's first observed failure, so the timeout
+// has a start time to measure from.
+func (c *controllerCommon) shouldForceDetach(diskURI string) bool {
+	if c.cloud.DetachDiskForceTimeoutMinutes <= 0 {
+		return false
+	}
+
+	c.diskDetachFailuresLock.Lock()
+	defer c.diskDetachFailuresLock.Unlock()
+	if c.diskDetachFailures == nil {
+		c.diskDetachFailures = make(map[string]time.Time)
+	}
+
+	firstFailure, seenBefore := c.diskDetachFailures[diskURI]
+	if !seenBefore {
+		c.diskDetachFailures[diskURI] = time.Now()
+		return false
+	}
+	return time.Since(firstFailure) >= time.Duration(c.cloud.DetachDiskForceTimeoutMinutes)*time.Minute
+}
+
+func (c *controllerCommon) clearDetachFailure(diskURI string) {
+	c.diskDetachFailuresLock.Lock()
+	defer c.diskDetachFailuresLock.Unlock()
+	delete(c.diskDetachFailures, diskURI)
+}
+
 // DetachDiskByName detaches a vhd from host
 // the vhd can be identified by diskName or diskURI
 func (c *controllerCommon) DetachDiskByName(diskName, diskURI string, nodeName types.NodeName) error {
+	logger := forOperation("DetachDiskByName").withNode(string(nodeName)).withDiskURI(diskURI)
+
+	if c.shouldForceDetach(diskURI) {
+		// The VM update below already unconditionally drops the disk from the VM's data disk
+		// list - what "force" adds is breaking the underlying blob lease directly, in case the
+		// node that had the disk attached is unresponsive and never released it itself. Best
+		// effort: an unmanaged disk lease that's already gone (or a managed disk, which has no
+		// blob lease at all) returns an error here that we log and otherwise ignore, since the
+		// VM update is what actually matters for unblocking a StatefulSet failover.
+		msg := fmt.Sprintf("disk %s has been failing to detach from node %q for over %d minutes, forcing detach and breaking its lease", diskName, nodeName, c.cloud.DetachDiskForceTimeoutMinutes)
+		logger.Warningf(msg)
+		// eventRecorder is nil until Initialize runs (e.g. in unit tests that build a Cloud
+		// directly), so this only fires once the provider is actually wired up to a cluster.
+		if c.cloud.eventRecorder != nil {
+			c.cloud.eventRecorder.Event(&v1.ObjectReference{Kind: "Node", Name: string(nodeName)}, v1.EventTypeWarning, "ForcedDiskDetach", msg)
+		}
+		if err := c.cloud.breakBlobLease(diskURI); err != nil {
+			logger.V(2, "force detach: breaking lease for disk %s failed (ignoring): %v", diskName, err)
+		}
+	}
+
 	vm, exists, err := c.cloud.getVirtualMachine(nodeName)
 	if err != nil || !exists {
 		// if host doesn't exist, no need to detach
-		glog.Warningf("azureDisk - cannot find node %s, skip detaching disk %s", nodeName, diskName)
+		logger.Warningf("cannot find node, skip detaching disk %s", diskName)
+		c.clearDetachFailure(diskURI)
 		return nil
 	}
 
@@ -174,28 +231,78 @@ func (c *controllerCommon) DetachDiskByName(diskName, diskURI string, nodeName t
 			},
 		},
 	}
-	vmName := mapNodeNameToVMName(nodeName)
-	glog.V(2).Infof("azureDisk - update(%s): vm(%s) - detach disk", c.resourceGroup, vmName)
+	vmName := c.cloud.mapNodeNameToVMName(nodeName)
+	logger.V(2, "updating vm(%s) to detach disk", vmName)
 	c.cloud.operationPollRateLimiter.Accept()
+	c.cloud.operationPool.acquire()
 	respChan, errChan := c.cloud.VirtualMachinesClient.CreateOrUpdate(c.resourceGroup, vmName, newVM, nil)
 	resp := <-respChan
 	err = <-errChan
+	c.cloud.operationPool.release()
+	c.cloud.vmCache.invalidate(vmName)
 	if c.cloud.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
-		glog.V(2).Infof("azureDisk - update(%s) backing off: vm(%s)", c.resourceGroup, vmName)
+		logger.V(2, "backing off update of vm(%s)", vmName)
 		retryErr := c.cloud.CreateOrUpdateVMWithRetry(vmName, newVM)
 		if retryErr != nil {
 			err = retryErr
-			glog.V(2).Infof("azureDisk - update(%s) abort backoff: vm(%s)", c.cloud.ResourceGroup, vmName)
+			logger.V(2, "abort backoff for update of vm(%s)", vmName)
 		}
 	}
 	if err != nil {
-		glog.Errorf("azureDisk - azure disk detach failed, err: %v", err)
+		logger.Errorf("azure disk detach failed: %v", err)
 	} else {
-		glog.V(4).Infof("azureDisk - azure disk detach succeeded")
+		logger.V(4, "azure disk detach succeeded")
+		c.clearDetachFailure(diskURI)
 	}
 	return err
 }
 
+// ResizeDisk grows a managed disk to newSizeGB and returns the size in GiB ARM actually settled
+// on. Unmanaged (VHD-backed) disks aren't resizable through ARM - growing one means resizing its
+// page blob and rewriting the VHD footer, which this provider doesn't implement - so those
+// requests fail loudly instead of silently doing nothing.
+func (c *controllerCommon) ResizeDisk(isManagedDisk bool, diskURI string, newSizeGB int) (int, error) {
+	if !isManagedDisk {
+		return 0, fmt.Errorf("azureDisk - resize is only supported for managed disks, diskURI: %s", diskURI)
+	}
+
+	logger := forOperation("ResizeDisk").withDiskURI(diskURI)
+	diskName := path.Base(diskURI)
+
+	result, err := c.cloud.DisksClient.Get(c.resourceGroup, diskName)
+	if err != nil {
+		return 0, err
+	}
+	if result.DiskSizeGB != nil && int(*result.DiskSizeGB) >= newSizeGB {
+		logger.V(4, "disk %s is already %dGB, no resize needed", diskName, *result.DiskSizeGB)
+		return int(*result.DiskSizeGB), nil
+	}
+
+	size := int32(newSizeGB)
+	update := azdisk.UpdateType{
+		UpdateProperties: &azdisk.UpdateProperties{
+			DiskSizeGB: &size,
+		},
+	}
+
+	logger.V(2, "resizing disk %s to %dGB", diskName, newSizeGB)
+	c.cloud.operationPollRateLimiter.Accept()
+	c.cloud.operationPool.acquire()
+	respChan, errChan := c.cloud.DisksClient.Update(c.resourceGroup, diskName, update, nil)
+	resp := <-respChan
+	err = <-errChan
+	c.cloud.operationPool.release()
+	if err != nil {
+		logger.Errorf("failed to resize disk %s: %v", diskName, err)
+		return 0, err
+	}
+
+	if resp.DiskSizeGB != nil {
+		return int(*resp.DiskSizeGB), nil
+	}
+	return newSizeGB, nil
+}
+
 // GetDiskLun finds the lun on the host that the vhd is attached to, given a vhd's diskName and diskURI
 func (c *controllerCommon) GetDiskLun(diskName, diskURI string, nodeName types.NodeName) (int32, error) {
 	vm, exists, err := c.cloud.getVirtualMachine(nodeName)
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/golang/glog"
+)
+
+const (
+	headerCorrelationRequestID = "x-ms-correlation-request-id"
+	headerRequestID            = "x-ms-request-id"
+)
+
+// correlationIDSender logs the x-ms-correlation-request-id and x-ms-request-id ARM stamps on
+// every response so a failure reported by a user can be matched against the corresponding entry
+// in the subscription's Azure Activity Log during a support escalation.
+//
+// It only logs; it deliberately does not wrap the returned error. autorest.DetailedError (the
+// type checkResourceExistsFromError and friends type-assert on) isn't constructed until after
+// Do returns, higher up in the autorest client stack, so there is nothing for this Sender to
+// attach the IDs to without breaking those assertions.
+type correlationIDSender struct {
+	next autorest.Sender
+}
+
+func (s *correlationIDSender) Do(req *http.Request) (*http.Response, error) {
+	resp, err := s.next.Do(req)
+	if resp == nil {
+		return resp, err
+	}
+
+	correlationID := resp.Header.Get(headerCorrelationRequestID)
+	requestID := resp.Header.Get(headerRequestID)
+	if correlationID == "" && requestID == "" {
+		return resp, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		glog.Errorf("azure: %s %s failed with status %d, correlation-request-id=%s request-id=%s",
+			req.Method, req.URL.Path, resp.StatusCode, correlationID, requestID)
+	} else {
+		glog.V(2).Infof("azure: %s %s -> %d, correlation-request-id=%s request-id=%s",
+			req.Method, req.URL.Path, resp.StatusCode, correlationID, requestID)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/golang/glog"
+)
+
+// apiCallCounters tallies outbound ARM requests by armOperationKey, for the debug endpoint
+// below.
+type apiCallCounters struct {
+	mutex  sync.Mutex
+	counts map[string]uint64
+}
+
+func newAPICallCounters() *apiCallCounters {
+	return &apiCallCounters{counts: map[string]uint64{}}
+}
+
+func (c *apiCallCounters) increment(op string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[op]++
+}
+
+func (c *apiCallCounters) snapshot() map[string]uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	out := make(map[string]uint64, len(c.counts))
+	for k, v := range c.counts {
+		out[k] = v
+	}
+	return out
+}
+
+// armResourceType extracts the ARM resource type from a request path, e.g.
+// "Microsoft.Compute/virtualMachines", stripping the resource group and object name so counts
+// and log records aggregate across every VM/LB/NIC/etc. instead of growing one entry per object.
+// Falls back to the raw path if it isn't shaped like a provider resource request.
+func armResourceType(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, s := range segments {
+		if strings.EqualFold(s, "providers") && i+2 < len(segments) {
+			return fmt.Sprintf("%s/%s", segments[i+1], segments[i+2])
+		}
+	}
+	return req.URL.Path
+}
+
+// armOperationKey buckets a request by HTTP method and ARM resource type, e.g. "GET
+// Microsoft.Compute/virtualMachines", for the API call counters below.
+func armOperationKey(req *http.Request) string {
+	return fmt.Sprintf("%s %s", req.Method, armResourceType(req))
+}
+
+// debugCountingSender increments an apiCallCounters entry for every outbound ARM request, so
+// DebugHandler below can report per-operation call volume.
+type debugCountingSender struct {
+	next     autorest.Sender
+	counters *apiCallCounters
+}
+
+func (s *debugCountingSender) Do(req *http.Request) (*http.Response, error) {
+	s.counters.increment(armOperationKey(req))
+	return s.next.Do(req)
+}
+
+// DebugHandler returns an http.Handler that dumps the provider's in-memory cache sizes, cached
+// storage account state, and per-operation ARM call counters as JSON, for troubleshooting.
+func (az *Cloud) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dump := struct {
+			CacheSizes      map[string]int                 `json:"cacheSizes"`
+			StorageAccounts map[string]storageAccountState `json:"storageAccounts,omitempty"`
+			APICallCounts   map[string]uint64              `json:"apiCallCounts"`
+		}{
+			CacheSizes: map[string]int{
+				"vm":       az.vmCache.size(),
+				"nic":      az.nicCache.size(),
+				"lb":       az.lbCache.size(),
+				"nsg":      az.nsgCache.size(),
+				"publicIP": az.publicIPCache.size(),
+			},
+			APICallCounts: az.apiCallCounters.snapshot(),
+		}
+		if az.BlobDiskController != nil {
+			dump.StorageAccounts = az.BlobDiskController.accountsSnapshot()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(dump); err != nil {
+			glog.Errorf("azure: failed to encode debug dump: %v", err)
+		}
+	})
+}
+
+// startDebugServer starts a localhost-only HTTP server serving DebugHandler at /debug/azure, if
+// CloudProviderDebugAddr is configured. It refuses to bind anything but a loopback address,
+// since the dump can include resource group and subnet identifiers.
+func (az *Cloud) startDebugServer() {
+	if az.CloudProviderDebugAddr == "" {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(az.CloudProviderDebugAddr)
+	if err != nil || !isLoopbackHost(host) {
+		glog.Errorf("azure: cloudProviderDebugAddr %q is not a loopback address, debug endpoint not started", az.CloudProviderDebugAddr)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/azure", az.DebugHandler())
+	go func() {
+		glog.V(2).Infof("azure: serving debug endpoint on %s", az.CloudProviderDebugAddr)
+		if err := http.ListenAndServe(az.CloudProviderDebugAddr, mux); err != nil {
+			glog.Errorf("azure: debug endpoint on %s stopped: %v", az.CloudProviderDebugAddr, err)
+		}
+	}()
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -25,8 +25,22 @@ import (
 
 const (
 	useHTTPS = true
+
+	// shareBeingDeletedErrorCode is the AzureStorageServiceError.Code returned when creating a
+	// share with the same name as one that's still in its soft-delete retention window.
+	shareBeingDeletedErrorCode = "ShareBeingDeleted"
 )
 
+// errShareSoftDeleted is returned when a file share can't be created because a share of the
+// same name is soft-deleted and still within its retention period. Recovering from this
+// automatically would need either the Restore Share API (which sets x-ms-deleted-share-name/
+// x-ms-deleted-share-version headers on Create Share) or a way to purge the soft-deleted share
+// immediately, and the vendored storage SDK's Share.Create exposes neither.
+var errShareSoftDeleted = fmt.Errorf("a share with this name is soft-deleted and still within its retention " +
+	"window; the vendored Azure storage SDK can't undelete or purge it, so it must be restored or permanently " +
+	"deleted out-of-band (e.g. with az storage share-rm restore / az storage share-rm delete --permanent-delete) " +
+	"before a share with the same name can be created again")
+
 // create file share
 func (az *Cloud) createFileShare(accountName, accountKey, name string, sizeGB int) error {
 	fileClient, err := az.getFileSvcClient(accountName, accountKey)
@@ -40,6 +54,9 @@ func (az *Cloud) createFileShare(accountName, accountKey, name string, sizeGB in
 	// As a result,breaking into two API calls: create share and set quota
 	share := fileClient.GetShareReference(name)
 	if err = share.Create(nil); err != nil {
+		if serviceErr, ok := err.(azs.AzureStorageServiceError); ok && serviceErr.Code == shareBeingDeletedErrorCode {
+			return errShareSoftDeleted
+		}
 		return fmt.Errorf("failed to create file share, err: %v", err)
 	}
 	share.Properties.Quota = sizeGB
@@ -52,6 +69,67 @@ func (az *Cloud) createFileShare(accountName, accountKey, name string, sizeGB in
 	return nil
 }
 
+// getFileShareCount counts the shares that exist in a storage account, for use by the
+// pooling logic in CreateFileShare when deciding which shared account to place a new share
+// in, or whether to create a new one instead.
+func (az *Cloud) getFileShareCount(accountName, accountKey string) (int, error) {
+	fileClient, err := az.getFileSvcClient(accountName, accountKey)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	params := azs.ListSharesParameters{}
+	for {
+		resp, err := fileClient.ListShares(params)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list shares in storage account %s, err: %v", accountName, err)
+		}
+		count += len(resp.Shares)
+		if resp.NextMarker == "" {
+			break
+		}
+		params.Marker = resp.NextMarker
+	}
+	return count, nil
+}
+
+// resize a file share
+func (az *Cloud) resizeFileShare(accountName, accountKey, name string, sizeGB int) error {
+	fileClient, err := az.getFileSvcClient(accountName, accountKey)
+	if err != nil {
+		return err
+	}
+	share := fileClient.GetShareReference(name)
+	share.Properties.Quota = sizeGB
+	if err = share.SetProperties(nil); err != nil {
+		return fmt.Errorf("failed to set quota on file share %s, err: %v", name, err)
+	}
+	return nil
+}
+
+// errFileShareSnapshotUnsupported documents why the share-snapshot family of operations
+// can't be implemented against this tree's vendored storage SDK: creating one is a PUT
+// Share Properties call carrying an x-ms-snapshot response header, and listing them is a
+// List Shares call with an "include=snapshots" filter, neither of which
+// azs.FileServiceClient/azs.Share expose.
+var errFileShareSnapshotUnsupported = fmt.Errorf("azure file share snapshots require a newer Azure storage SDK than the one vendored into this tree")
+
+// create a snapshot of a file share
+func (az *Cloud) createFileShareSnapshot(accountName, accountKey, name string) (string, error) {
+	return "", errFileShareSnapshotUnsupported
+}
+
+// delete a snapshot of a file share
+func (az *Cloud) deleteFileShareSnapshot(accountName, accountKey, name, snapshot string) error {
+	return errFileShareSnapshotUnsupported
+}
+
+// list the snapshots of a file share
+func (az *Cloud) listFileShareSnapshots(accountName, accountKey, name string) ([]string, error) {
+	return nil, errFileShareSnapshotUnsupported
+}
+
 // delete a file share
 func (az *Cloud) deleteFileShare(accountName, accountKey, name string) error {
 	fileClient, err := az.getFileSvcClient(accountName, accountKey)
@@ -67,6 +145,12 @@ func (az *Cloud) getFileSvcClient(accountName, accountKey string) (*azs.FileServ
 	if err != nil {
 		return nil, fmt.Errorf("error creating azure client: %v", err)
 	}
+	if az.httpClient != nil {
+		client.HTTPClient = az.httpClient
+	}
+	if az.ClusterName != "" {
+		client.AddToUserAgent(fmt.Sprintf("cluster/%s", az.ClusterName))
+	}
 	f := client.GetFileService()
 	return &f, nil
 }
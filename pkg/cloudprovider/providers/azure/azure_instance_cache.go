@@ -0,0 +1,167 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+// instanceExistsCacheTTL bounds how stale a cached InstanceExistsByProviderID answer is
+// allowed to be when the node informer hasn't observed a delete for it in the meantime.
+const instanceExistsCacheTTL = 2 * time.Minute
+
+// instanceExistsCache memoizes InstanceExistsByProviderID results, keyed by providerID.
+// Entries are invalidated eagerly when the node informer observes the corresponding Node
+// object being deleted, and lazily via TTL expiry otherwise, so a stuck Azure API doesn't
+// force every reconcile loop to re-query the VM.
+type instanceExistsCache struct {
+	mutex sync.Mutex
+	byID  map[string]instanceExistsCacheEntry
+}
+
+type instanceExistsCacheEntry struct {
+	exists  bool
+	expires time.Time
+}
+
+func newInstanceExistsCache() *instanceExistsCache {
+	return &instanceExistsCache{byID: map[string]instanceExistsCacheEntry{}}
+}
+
+func (c *instanceExistsCache) get(providerID string) (exists bool, found bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.byID[providerID]
+	if !ok || time.Now().After(entry.expires) {
+		return false, false
+	}
+	return entry.exists, true
+}
+
+func (c *instanceExistsCache) set(providerID string, exists bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.byID[providerID] = instanceExistsCacheEntry{exists: exists, expires: time.Now().Add(instanceExistsCacheTTL)}
+}
+
+func (c *instanceExistsCache) invalidate(providerID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.byID, providerID)
+}
+
+// nodeDeletionHook is invoked once for every Node object the informer started by
+// watchNodeDeletions observes being deleted, so cleanup that needs to react promptly to a
+// node going away - rather than waiting on the next periodic reconcile - can be added
+// without growing the informer's event handler itself.
+type nodeDeletionHook func(node *v1.Node)
+
+// nodeUpsertHook is invoked once for every Node object the informer started by
+// watchNodeDeletions observes being added, or updated (including a Node's spec.ProviderID
+// being filled in once the kubelet registers, or changing if the underlying VM is ever
+// recreated under the same Node object).
+type nodeUpsertHook func(node *v1.Node)
+
+// watchNodeDeletions runs a Node informer for the lifetime of the process, calling every
+// registered nodeUpsertHook on Node add/update and every registered nodeDeletionHook as soon
+// as a Node's deletion is observed.
+func (az *Cloud) watchNodeDeletions(deletionHooks []nodeDeletionHook, upsertHooks ...nodeUpsertHook) {
+	runUpsertHooks := func(obj interface{}) {
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			return
+		}
+		for _, hook := range upsertHooks {
+			hook(node)
+		}
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: runUpsertHooks,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			runUpsertHooks(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			node, ok := obj.(*v1.Node)
+			if !ok {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					node, ok = tombstone.Obj.(*v1.Node)
+					if !ok {
+						return
+					}
+				} else {
+					return
+				}
+			}
+			for _, hook := range deletionHooks {
+				hook(node)
+			}
+		},
+	}
+
+	listWatch := cache.NewListWatchFromClient(az.kubeClient.Core().RESTClient(), "nodes", "", fields.Everything())
+	_, controller := cache.NewInformer(listWatch, &v1.Node{}, 0, handler)
+	go controller.Run(wait.NeverStop)
+}
+
+// invalidateInstanceExistsCacheOnDeletion is a nodeDeletionHook that drops the
+// instance-exists cache entry for a deleted node immediately, instead of waiting for the
+// TTL to lapse.
+func (az *Cloud) invalidateInstanceExistsCacheOnDeletion(node *v1.Node) {
+	if node.Spec.ProviderID == "" {
+		return
+	}
+	glog.V(4).Infof("azure: invalidating instance-exists cache for deleted node %s (%s)", node.Name, node.Spec.ProviderID)
+	az.instanceExistsCache.invalidate(node.Spec.ProviderID)
+}
+
+// invalidatePowerStateCacheOnDeletion is a nodeDeletionHook that drops the power-state
+// poller's cached entry for a deleted node immediately, so a recreated node reusing the
+// same VM name doesn't briefly inherit a stale shutdown state.
+func (az *Cloud) invalidatePowerStateCacheOnDeletion(node *v1.Node) {
+	if az.powerState == nil {
+		return
+	}
+	az.powerState.delete(az.mapNodeNameToVMName(types.NodeName(node.Name)))
+}
+
+// invalidateVMCacheOnUpsert is a nodeUpsertHook that drops the cached VirtualMachine for a
+// node's VM the moment the informer observes it being added or updated, so a Node whose
+// spec.ProviderID is filled in (or changed, if the underlying VM is ever recreated under the
+// same Node object) never has to wait out azureObjectCacheTTL to see the change.
+//
+// Only vmCache is handled here: nicCache's key (the NIC name) isn't derivable from a Node
+// object without a VM lookup, and a NIC's identity - unlike its configuration, which is
+// already invalidated explicitly at every mutating call site - essentially never changes for
+// the life of a node, so its short TTL is enough.
+func (az *Cloud) invalidateVMCacheOnUpsert(node *v1.Node) {
+	az.vmCache.invalidate(az.mapNodeNameToVMName(types.NodeName(node.Name)))
+}
+
+// invalidateVMCacheOnDeletion is a nodeDeletionHook that drops the cached VirtualMachine for a
+// deleted node immediately, mirroring invalidateInstanceExistsCacheOnDeletion.
+func (az *Cloud) invalidateVMCacheOnDeletion(node *v1.Node) {
+	az.vmCache.invalidate(az.mapNodeNameToVMName(types.NodeName(node.Name)))
+}
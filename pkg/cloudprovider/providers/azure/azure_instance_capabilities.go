@@ -0,0 +1,97 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import "strings"
+
+const (
+	labelPremiumStorage        = "alpha.service-controller.kubernetes.io/premium-storage-supported"
+	labelAcceleratedNetworking = "alpha.service-controller.kubernetes.io/accelerated-networking"
+	labelAcceleratorType       = "alpha.service-controller.kubernetes.io/accelerator-type"
+)
+
+// gpuSizePrefixes lists the VM size family prefixes that carry a GPU, keyed to the
+// accelerator label value node labelling should apply.
+var gpuSizePrefixes = map[string]string{
+	"Standard_NC": "nvidia",
+	"Standard_ND": "nvidia",
+	"Standard_NV": "nvidia",
+}
+
+// acceleratedNetworkingSizePrefixes lists the VM sizes that support accelerated networking
+// (SR-IOV). A size matches only if it is listed here exactly.
+var acceleratedNetworkingSizePrefixes = []string{
+	"Standard_D2s_v3", "Standard_D4s_v3", "Standard_D8s_v3", "Standard_D16s_v3", "Standard_D32s_v3", "Standard_D64s_v3",
+	"Standard_E2s_v3", "Standard_E4s_v3", "Standard_E8s_v3", "Standard_E16s_v3", "Standard_E32s_v3", "Standard_E64s_v3",
+	"Standard_F4s", "Standard_F8s", "Standard_F16s",
+	"Standard_F4s_v2", "Standard_F8s_v2", "Standard_F16s_v2", "Standard_F32s_v2", "Standard_F64s_v2", "Standard_F72s_v2",
+}
+
+// InstanceCapabilityLabels derives best-effort scheduling capability labels for the given
+// VM size, for the caller (typically the kubelet's node-labelling path) to apply to the
+// Node object alongside the normal beta.kubernetes.io/instance-type label.
+//
+// NOTE: the vendored Azure Compute SDK has neither a ResourceSkus client nor any
+// capability fields on VirtualMachineSize (it only exposes core/memory/disk sizing), so
+// there is no authoritative API to query accelerated-networking, GPU, or premium-storage
+// support. Until the SDK gains that, capabilities are inferred from well-known VM size
+// naming conventions below; sizes outside these tables simply get no capability labels
+// rather than a wrong guess.
+func InstanceCapabilityLabels(vmSize string) map[string]string {
+	labels := map[string]string{}
+
+	if isPremiumStorageSize(vmSize) {
+		labels[labelPremiumStorage] = "true"
+	}
+
+	for _, prefix := range acceleratedNetworkingSizePrefixes {
+		if vmSize == prefix {
+			labels[labelAcceleratedNetworking] = "true"
+			break
+		}
+	}
+
+	for prefix, accelerator := range gpuSizePrefixes {
+		if strings.HasPrefix(vmSize, prefix) {
+			labels[labelAcceleratorType] = accelerator
+			break
+		}
+	}
+
+	return labels
+}
+
+// isPremiumStorageSize reports whether vmSize belongs to a size family whose name marks
+// Premium Storage support with an "s", either right after the family letter(s) or right
+// after the core count, e.g. Standard_DS2_v2, Standard_GS5, Standard_E4s_v3. This mirrors
+// the naming rule Azure itself documents for identifying Premium Storage-capable sizes.
+func isPremiumStorageSize(vmSize string) bool {
+	family := strings.TrimPrefix(vmSize, "Standard_")
+	for i, r := range family {
+		if r >= '0' && r <= '9' {
+			if i > 0 && strings.ContainsRune("sS", rune(family[i-1])) {
+				return true
+			}
+			j := i
+			for j < len(family) && family[j] >= '0' && family[j] <= '9' {
+				j++
+			}
+			return j < len(family) && family[j] == 's'
+		}
+	}
+	return false
+}
@@ -17,8 +17,8 @@ limitations under the License.
 package azure
 
 import (
-	"errors"
 	"fmt"
+	"strings"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/kubernetes/pkg/cloudprovider"
@@ -31,50 +31,59 @@ import (
 // NodeAddresses returns the addresses of the specified instance.
 func (az *Cloud) NodeAddresses(name types.NodeName) ([]v1.NodeAddress, error) {
 	if az.UseInstanceMetadata {
-		ipAddress := IPAddress{}
-		err := az.metadata.Object("instance/network/interface/0/ipv4/ipAddress/0", &ipAddress)
+		ipAddresses := []IPAddress{}
+		err := az.metadata.Object("instance/network/interface/0/ipv4/ipAddress", &ipAddresses)
 		if err != nil {
 			return nil, err
 		}
+		if len(ipAddresses) == 0 {
+			return nil, fmt.Errorf("no ipv4 addresses found in instance metadata")
+		}
 		addresses := []v1.NodeAddress{
-			{Type: v1.NodeInternalIP, Address: ipAddress.PrivateIP},
+			{Type: v1.NodeInternalIP, Address: ipAddresses[0].PrivateIP},
 			{Type: v1.NodeHostName, Address: string(name)},
 		}
-		if len(ipAddress.PublicIP) > 0 {
-			addr := v1.NodeAddress{
+		if len(ipAddresses[0].PublicIP) > 0 {
+			addresses = append(addresses, v1.NodeAddress{
 				Type:    v1.NodeExternalIP,
-				Address: ipAddress.PublicIP,
+				Address: ipAddresses[0].PublicIP,
+			})
+		}
+		// Any remaining entries are secondary IP configurations on the primary NIC.
+		for _, secondary := range ipAddresses[1:] {
+			if len(secondary.PrivateIP) > 0 {
+				addresses = append(addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: secondary.PrivateIP})
+			}
+			if len(secondary.PublicIP) > 0 {
+				addresses = append(addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: secondary.PublicIP})
 			}
-			addresses = append(addresses, addr)
 		}
 		return addresses, nil
 	}
-	ip, err := az.getIPForMachine(name)
+
+	addresses, err := az.getNodeAddresses(name)
 	if err != nil {
 		if az.CloudProviderBackoff {
 			glog.V(2).Infof("NodeAddresses(%s) backing off", name)
-			ip, err = az.GetIPForMachineWithRetry(name)
+			addresses, err = az.GetNodeAddressesWithRetry(name)
 			if err != nil {
 				glog.V(2).Infof("NodeAddresses(%s) abort backoff", name)
 				return nil, err
 			}
 		} else {
-			glog.Errorf("error: az.NodeAddresses, az.getIPForMachine(%s), err=%v", name, err)
+			glog.Errorf("error: az.NodeAddresses, az.getNodeAddresses(%s), err=%v", name, err)
 			return nil, err
 		}
 	}
 
-	return []v1.NodeAddress{
-		{Type: v1.NodeInternalIP, Address: ip},
-		{Type: v1.NodeHostName, Address: string(name)},
-	}, nil
+	return addresses, nil
 }
 
 // NodeAddressesByProviderID returns the node addresses of an instances with the specified unique providerID
 // This method will not be called from the node that is requesting this ID. i.e. metadata service
 // and other local methods cannot be used here
 func (az *Cloud) NodeAddressesByProviderID(providerID string) ([]v1.NodeAddress, error) {
-	name, err := splitProviderID(providerID)
+	name, err := az.resolveProviderID(providerID)
 	if err != nil {
 		return nil, err
 	}
@@ -89,12 +98,85 @@ func (az *Cloud) ExternalID(name types.NodeName) (string, error) {
 
 // InstanceExistsByProviderID returns true if the instance with the given provider id still exists and is running.
 // If false is returned with no error, the instance will be immediately deleted by the cloud controller manager.
+//
+// Results are cached in az.instanceExistsCache to avoid re-querying the VM on every
+// reconcile; the cache entry is invalidated as soon as the node informer observes the
+// Node's deletion, and otherwise expires after instanceExistsCacheTTL.
 func (az *Cloud) InstanceExistsByProviderID(providerID string) (bool, error) {
-	return false, errors.New("unimplemented")
+	if exists, found := az.instanceExistsCache.get(providerID); found {
+		return exists, nil
+	}
+
+	name, err := az.resolveProviderID(providerID)
+	if err != nil {
+		if err == cloudprovider.InstanceNotFound {
+			az.instanceExistsCache.set(providerID, false)
+			return false, nil
+		}
+		return false, err
+	}
+
+	_, exists, err := az.getVirtualMachine(name)
+	if err != nil {
+		return false, err
+	}
+
+	az.instanceExistsCache.set(providerID, exists)
+	return exists, nil
+}
+
+// InstanceShutdownByProviderID returns true if the instance is in a stopped/deallocated
+// power state, so that the node controller can apply the shutdown taint instead of
+// waiting for it to disappear entirely.
+//
+// When the background power-state poller is enabled (PowerStatePollIntervalInSeconds > 0),
+// this answers from its cache instead of making a live ARM call.
+//
+// NOTE: the cloudprovider.Instances interface vendored into this tree predates this
+// method, so it isn't wired into the Instances interface yet; callers that know about
+// Azure specifically can still invoke it directly on the concrete *Cloud type.
+func (az *Cloud) InstanceShutdownByProviderID(providerID string) (bool, error) {
+	name, err := az.resolveProviderID(providerID)
+	if err != nil {
+		return false, err
+	}
+	vmName := az.mapNodeNameToVMName(name)
+
+	if az.powerState != nil {
+		if state, found := az.powerState.get(vmName); found {
+			return powerStateIsShutdown(state), nil
+		}
+	}
+
+	az.operationPollRateLimiter.Accept()
+	glog.V(10).Infof("VirtualMachinesClient.Get(%s): start", name)
+	vm, err := az.VirtualMachinesClient.Get(az.ResourceGroup, vmName, compute.InstanceView)
+	glog.V(10).Infof("VirtualMachinesClient.Get(%s): end", name)
+	if err != nil {
+		if exists, existsErr := checkResourceExistsFromError(err); existsErr == nil && !exists {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if vm.VirtualMachineProperties == nil || vm.InstanceView == nil || vm.InstanceView.Statuses == nil {
+		return false, nil
+	}
+
+	for _, status := range *vm.InstanceView.Statuses {
+		if status.Code == nil {
+			continue
+		}
+		if powerStateIsShutdown(*status.Code) {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
 func (az *Cloud) isCurrentInstance(name types.NodeName) (bool, error) {
-	nodeName := mapNodeNameToVMName(name)
+	nodeName := az.mapNodeNameToVMName(name)
 	metadataName, err := az.metadata.Text("instance/compute/name")
 	return (metadataName == nodeName), err
 }
@@ -114,6 +196,10 @@ func (az *Cloud) InstanceID(name types.NodeName) (string, error) {
 			}
 		}
 	}
+	if az.VMType == vmTypeVMSS {
+		return az.vmSet.GetInstanceIDByNodeName(az.mapNodeNameToVMName(name))
+	}
+
 	var machine compute.VirtualMachine
 	var exists bool
 	var err error
@@ -140,7 +226,7 @@ func (az *Cloud) InstanceID(name types.NodeName) (string, error) {
 // This method will not be called from the node that is requesting this ID. i.e. metadata service
 // and other local methods cannot be used here
 func (az *Cloud) InstanceTypeByProviderID(providerID string) (string, error) {
-	name, err := splitProviderID(providerID)
+	name, err := az.resolveProviderID(providerID)
 	if err != nil {
 		return "", err
 	}
@@ -165,6 +251,10 @@ func (az *Cloud) InstanceType(name types.NodeName) (string, error) {
 			}
 		}
 	}
+	if az.VMType == vmTypeVMSS {
+		return az.vmSet.GetInstanceTypeByNodeName(az.mapNodeNameToVMName(name))
+	}
+
 	machine, exists, err := az.getVirtualMachine(name)
 	if err != nil {
 		glog.Errorf("error: az.InstanceType(%s), az.getVirtualMachine(%s) err=%v", name, name, err)
@@ -175,6 +265,72 @@ func (az *Cloud) InstanceType(name types.NodeName) (string, error) {
 	return string(machine.HardwareProfile.VMSize), nil
 }
 
+// InstanceCapabilities returns the best-effort scheduling capability labels (premium
+// storage support, accelerated networking, GPU accelerator type) for the specified
+// instance's VM size. See InstanceCapabilityLabels for the caveats around how these are
+// derived.
+func (az *Cloud) InstanceCapabilities(name types.NodeName) (map[string]string, error) {
+	vmSize, err := az.InstanceType(name)
+	if err != nil {
+		return nil, err
+	}
+	return InstanceCapabilityLabels(vmSize), nil
+}
+
+// CombinedInstanceMetadata bundles the ProviderID, InstanceType, NodeAddresses, and Zone of a
+// single node - the fields kubelet's node registration and the node lifecycle controller
+// otherwise collect one at a time via InstanceID, InstanceType, NodeAddresses, and
+// GetZoneByNodeName.
+//
+// Named CombinedInstanceMetadata, not InstanceMetadata, to stay clear of the pre-existing
+// InstanceMetadata type in azure_instance_metadata.go, which is the IMDS (instance metadata
+// service) HTTP client and an unrelated concept.
+type CombinedInstanceMetadata struct {
+	ProviderID    string
+	InstanceType  string
+	NodeAddresses []v1.NodeAddress
+	Zone          string
+}
+
+// GetInstanceMetadata returns name's CombinedInstanceMetadata, fetching the underlying VM at
+// most once (via the same az.vmCache that InstanceID, InstanceType, NodeAddresses, and
+// GetZoneByNodeName already share) instead of a separate ARM call per field.
+//
+// NOTE: like InstanceShutdownByProviderID above, the cloudprovider.Instances/Zones interfaces
+// vendored into this tree predate any InstancesV2-style combined-lookup method, so this isn't
+// wired into either interface; it's exposed as a plain *Cloud method for a caller that already
+// knows it's talking to Azure - such as this provider's own controllers - to call directly.
+func (az *Cloud) GetInstanceMetadata(name types.NodeName) (*CombinedInstanceMetadata, error) {
+	machine, exists, err := az.getVirtualMachine(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, cloudprovider.InstanceNotFound
+	}
+
+	addresses, err := az.getNodeAddresses(name)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, err := availabilityZone(machine)
+	if err != nil {
+		// Fault domain information isn't always available (e.g. the VM hasn't finished
+		// provisioning yet) - degrade to an empty zone rather than failing the combined
+		// lookup over a field none of the other three need.
+		glog.V(4).Infof("azure: GetInstanceMetadata(%s): zone unavailable: %v", name, err)
+		zone = ""
+	}
+
+	return &CombinedInstanceMetadata{
+		ProviderID:    *machine.ID,
+		InstanceType:  string(machine.HardwareProfile.VMSize),
+		NodeAddresses: addresses,
+		Zone:          zone,
+	}, nil
+}
+
 // AddSSHKeyToAllInstances adds an SSH public key as a legal identity for all instances
 // expected format for the key is standard ssh-keygen format: <protocol> <blob>
 func (az *Cloud) AddSSHKeyToAllInstances(user string, keyData []byte) error {
@@ -220,14 +376,14 @@ func (az *Cloud) listAllNodesInResourceGroup() ([]compute.VirtualMachine, error)
 
 }
 
-// mapNodeNameToVMName maps a k8s NodeName to an Azure VM Name
-// This is a simple string cast.
-func mapNodeNameToVMName(nodeName types.NodeName) string {
-	return string(nodeName)
+// mapNodeNameToVMName maps a k8s NodeName to an Azure VM Name, applying
+// az.VMNamePrefix when the cluster's VM names don't match node names exactly.
+func (az *Cloud) mapNodeNameToVMName(nodeName types.NodeName) string {
+	return az.VMNamePrefix + string(nodeName)
 }
 
-// mapVMNameToNodeName maps an Azure VM Name to a k8s NodeName
-// This is a simple string cast.
-func mapVMNameToNodeName(vmName string) types.NodeName {
-	return types.NodeName(vmName)
+// mapVMNameToNodeName maps an Azure VM Name to a k8s NodeName, the inverse of
+// mapNodeNameToVMName.
+func (az *Cloud) mapVMNameToNodeName(vmName string) types.NodeName {
+	return types.NodeName(strings.TrimPrefix(vmName, az.VMNamePrefix))
 }
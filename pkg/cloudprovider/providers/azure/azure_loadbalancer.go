@@ -22,16 +22,24 @@ import (
 	"strings"
 
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
 	serviceapi "k8s.io/kubernetes/pkg/api/v1/service"
 
-	"github.com/Azure/azure-sdk-for-go/arm/compute"
 	"github.com/Azure/azure-sdk-for-go/arm/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/golang/glog"
 	"k8s.io/apimachinery/pkg/types"
 )
 
+// LoadBalancerCleanupFinalizer is added to a Service by the Azure provider before any
+// LB/PIP/NSG resources are created for it, and removed only after EnsureLoadBalancerDeleted
+// has finished cleaning those resources up. This prevents orphaned public IPs when a Service
+// is created and deleted in rapid succession, since the Service object won't disappear from
+// the API until the finalizer is removed.
+const LoadBalancerCleanupFinalizer = "service.kubernetes.io/azure-load-balancer-cleanup"
+
 // ServiceAnnotationLoadBalancerInternal is the annotation used on the service
 const ServiceAnnotationLoadBalancerInternal = "service.beta.kubernetes.io/azure-load-balancer-internal"
 
@@ -39,6 +47,45 @@ const ServiceAnnotationLoadBalancerInternal = "service.beta.kubernetes.io/azure-
 // to specify what subnet it is exposed on
 const ServiceAnnotationLoadBalancerInternalSubnet = "service.beta.kubernetes.io/azure-load-balancer-internal-subnet"
 
+// ServiceAnnotationHealthProbePort is the annotation used on the service to override the
+// port used for the LB's health probe. This is needed when the service's frontend is
+// chained through a Gateway Load Balancer or NVA fleet (see
+// ServiceAnnotationGatewayLoadBalancerFrontendIPConfigID), since health of the real
+// backend can no longer be inferred from the NodePort/health-check-node-port the
+// unmodified traffic would have used.
+const ServiceAnnotationHealthProbePort = "service.beta.kubernetes.io/azure-load-balancer-health-probe-port"
+
+// ServiceAnnotationLoadBalancerInternalVnet is the annotation used on the service to
+// place an internal LB's frontend in a VNet other than Config.VnetName, e.g. a peered
+// VNet that routes to the cluster's subnet.
+const ServiceAnnotationLoadBalancerInternalVnet = "service.beta.kubernetes.io/azure-load-balancer-internal-vnet"
+
+// ServiceAnnotationLoadBalancerInternalVnetResourceGroup is the annotation used together
+// with ServiceAnnotationLoadBalancerInternalVnet to specify the resource group that owns
+// that VNet, when it differs from Config.VnetResourceGroup.
+const ServiceAnnotationLoadBalancerInternalVnetResourceGroup = "service.beta.kubernetes.io/azure-load-balancer-internal-vnet-resource-group"
+
+// ServiceAnnotationDisableSecurityGroupManagement is the annotation used on the service to
+// opt it out of NSG rule reconciliation entirely, for clusters where NodePort exposure is
+// managed by an externally-owned NSG rather than by this provider.
+const ServiceAnnotationDisableSecurityGroupManagement = "service.beta.kubernetes.io/azure-disable-security-group-management"
+
+// ServiceAnnotationLoadBalancerEnableTCPReset is the annotation used on the service to
+// override the cluster-wide EnableTCPReset default for this service's LB rules. When
+// enabled, idle TCP connections are torn down with a TCP RST instead of being silently
+// dropped, so clients notice the failure sooner.
+const ServiceAnnotationLoadBalancerEnableTCPReset = "service.beta.kubernetes.io/azure-load-balancer-tcp-reset"
+
+// ServiceAnnotationGatewayLoadBalancerFrontendIPConfigID is the annotation used on the
+// service to chain the service's public frontend through a Gateway Load Balancer
+// frontend IP configuration, identified by its full ARM resource ID.
+const ServiceAnnotationGatewayLoadBalancerFrontendIPConfigID = "service.beta.kubernetes.io/azure-gateway-load-balancer-frontend-ip-config-id"
+
+// ServiceAnnotationLoadBalancerResourceTags is the annotation used on the service
+// to specify additional (or overriding) tags, in "k1=v1,k2=v2" form, applied on top
+// of Config.Tags to the LB, public IP, and NSG resources created for this service.
+const ServiceAnnotationLoadBalancerResourceTags = "service.beta.kubernetes.io/azure-load-balancer-resource-tags"
+
 // GetLoadBalancer returns whether the specified load balancer exists, and
 // if so, what its status is.
 func (az *Cloud) GetLoadBalancer(clusterName string, service *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
@@ -120,6 +167,12 @@ func (az *Cloud) determinePublicIPName(clusterName string, service *v1.Service)
 
 // EnsureLoadBalancer creates a new load balancer 'name', or updates the existing one. Returns the status of the balancer
 func (az *Cloud) EnsureLoadBalancer(clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	nodes = az.excludeExternallyManagedNodes(nodes)
+
+	if err := az.ensureLoadBalancerFinalizer(service); err != nil {
+		return nil, fmt.Errorf("ensure(%s): failed to add cleanup finalizer: %v", getServiceName(service), err)
+	}
+
 	isInternal := requiresInternalLoadBalancer(service)
 	lbName := getLoadBalancerName(clusterName, isInternal)
 
@@ -134,17 +187,21 @@ func (az *Cloud) EnsureLoadBalancer(clusterName string, service *v1.Service, nod
 	serviceName := getServiceName(service)
 	glog.V(5).Infof("ensure(%s): START clusterName=%q lbName=%q", serviceName, clusterName, lbName)
 
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("SecurityGroupsClient.Get(%q): start", az.SecurityGroupName)
-	sg, err := az.SecurityGroupsClient.Get(az.ResourceGroup, az.SecurityGroupName, "")
-	glog.V(10).Infof("SecurityGroupsClient.Get(%q): end", az.SecurityGroupName)
+	sg, existsSg, err := az.getSecurityGroup()
 	if err != nil {
 		return nil, err
 	}
+	if !existsSg {
+		return nil, fmt.Errorf("ensure(%s): security group %q not found", serviceName, az.SecurityGroupName)
+	}
 	sg, sgNeedsUpdate, err := az.reconcileSecurityGroup(sg, clusterName, service, true /* wantLb */)
 	if err != nil {
 		return nil, err
 	}
+	if tags := mergeTags(az.Tags, service.Annotations[ServiceAnnotationLoadBalancerResourceTags]); tags != nil && !tagsEqual(sg.Tags, tags) {
+		sg.Tags = &tags
+		sgNeedsUpdate = true
+	}
 	if sgNeedsUpdate {
 		glog.V(3).Infof("ensure(%s): sg(%s) - updating", serviceName, *sg.Name)
 		// azure-sdk-for-go introduced contraint validation which breaks the updating here if we don't set these
@@ -152,11 +209,14 @@ func (az *Cloud) EnsureLoadBalancer(clusterName string, service *v1.Service, nod
 		sg.SecurityGroupPropertiesFormat.NetworkInterfaces = nil
 		sg.SecurityGroupPropertiesFormat.Subnets = nil
 		az.operationPollRateLimiter.Accept()
+		az.operationPool.acquire()
 		glog.V(10).Infof("SecurityGroupsClient.CreateOrUpdate(%q): start", *sg.Name)
 		respChan, errChan := az.SecurityGroupsClient.CreateOrUpdate(az.ResourceGroup, *sg.Name, sg, nil)
 		resp := <-respChan
 		err := <-errChan
+		az.operationPool.release()
 		glog.V(10).Infof("SecurityGroupsClient.CreateOrUpdate(%q): end", *sg.Name)
+		az.nsgCache.invalidate(*sg.Name)
 		if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
 			glog.V(2).Infof("ensure(%s) backing off: sg(%s) - updating", serviceName, *sg.Name)
 			retryErr := az.CreateOrUpdateSGWithRetry(sg)
@@ -182,6 +242,12 @@ func (az *Cloud) EnsureLoadBalancer(clusterName string, service *v1.Service, nod
 		}
 	}
 
+	lbTagsUpdated := false
+	if tags := az.addClusterNameTag(mergeTags(az.Tags, service.Annotations[ServiceAnnotationLoadBalancerResourceTags])); tags != nil && !tagsEqual(lb.Tags, tags) {
+		lb.Tags = &tags
+		lbTagsUpdated = true
+	}
+
 	var lbIP *string
 	var fipConfigurationProperties *network.FrontendIPConfigurationPropertiesFormat
 
@@ -190,13 +256,21 @@ func (az *Cloud) EnsureLoadBalancer(clusterName string, service *v1.Service, nod
 		if subnetName == nil {
 			subnetName = &az.SubnetName
 		}
-		subnet, existsSubnet, err := az.getSubnet(az.VnetName, *subnetName)
+		vnetName := az.VnetName
+		if v, ok := service.Annotations[ServiceAnnotationLoadBalancerInternalVnet]; ok && v != "" {
+			vnetName = v
+		}
+		vnetResourceGroup := az.VnetResourceGroup
+		if rg, ok := service.Annotations[ServiceAnnotationLoadBalancerInternalVnetResourceGroup]; ok && rg != "" {
+			vnetResourceGroup = rg
+		}
+		subnet, existsSubnet, err := az.getSubnetInResourceGroup(vnetResourceGroup, vnetName, *subnetName)
 		if err != nil {
 			return nil, err
 		}
 
 		if !existsSubnet {
-			return nil, fmt.Errorf("ensure(%s): lb(%s) - failed to get subnet: %s/%s", serviceName, lbName, az.VnetName, az.SubnetName)
+			return nil, fmt.Errorf("ensure(%s): lb(%s) - failed to get subnet: %s/%s", serviceName, lbName, vnetName, *subnetName)
 		}
 
 		configProperties := network.FrontendIPConfigurationPropertiesFormat{
@@ -217,11 +291,19 @@ func (az *Cloud) EnsureLoadBalancer(clusterName string, service *v1.Service, nod
 
 		fipConfigurationProperties = &configProperties
 	} else {
+		if _, ok := service.Annotations[ServiceAnnotationGatewayLoadBalancerFrontendIPConfigID]; ok {
+			// TODO: the vendored azure-sdk-for-go/arm/network in this tree predates the
+			// gatewayLoadBalancer property on PublicIPAddressPropertiesFormat, so we can't
+			// yet wire the frontend through a Gateway LB. Fail loudly instead of silently
+			// ignoring the annotation once the SDK is bumped this should chain the frontend.
+			return nil, fmt.Errorf("ensure(%s): %s is set but this build's Azure SDK does not support Gateway Load Balancer chaining", serviceName, ServiceAnnotationGatewayLoadBalancerFrontendIPConfigID)
+		}
+
 		pipName, err := az.determinePublicIPName(clusterName, service)
 		if err != nil {
 			return nil, err
 		}
-		pip, err := az.ensurePublicIPExists(serviceName, pipName)
+		pip, err := az.ensurePublicIPExists(service, serviceName, pipName)
 		if err != nil {
 			return nil, err
 		}
@@ -236,14 +318,18 @@ func (az *Cloud) EnsureLoadBalancer(clusterName string, service *v1.Service, nod
 	if err != nil {
 		return nil, err
 	}
+	lbNeedsUpdate = lbNeedsUpdate || lbTagsUpdated
 	if !existsLb || lbNeedsUpdate {
 		glog.V(3).Infof("ensure(%s): lb(%s) - updating", serviceName, lbName)
 		az.operationPollRateLimiter.Accept()
+		az.operationPool.acquire()
 		glog.V(10).Infof("LoadBalancerClient.CreateOrUpdate(%q): start", *lb.Name)
 		respChan, errChan := az.LoadBalancerClient.CreateOrUpdate(az.ResourceGroup, *lb.Name, lb, nil)
 		resp := <-respChan
 		err := <-errChan
+		az.operationPool.release()
 		glog.V(10).Infof("LoadBalancerClient.CreateOrUpdate(%q): end", *lb.Name)
+		az.lbCache.invalidate(*lb.Name)
 		if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
 			glog.V(2).Infof("ensure(%s) backing off: lb(%s) - updating", serviceName, lbName)
 			retryErr := az.CreateOrUpdateLBWithRetry(lb)
@@ -336,11 +422,14 @@ func (az *Cloud) EnsureLoadBalancerDeleted(clusterName string, service *v1.Servi
 			sg.SecurityGroupPropertiesFormat.NetworkInterfaces = nil
 			sg.SecurityGroupPropertiesFormat.Subnets = nil
 			az.operationPollRateLimiter.Accept()
+			az.operationPool.acquire()
 			glog.V(10).Infof("SecurityGroupsClient.CreateOrUpdate(%q): start", *reconciledSg.Name)
 			respChan, errChan := az.SecurityGroupsClient.CreateOrUpdate(az.ResourceGroup, *reconciledSg.Name, reconciledSg, nil)
 			resp := <-respChan
 			err := <-errChan
+			az.operationPool.release()
 			glog.V(10).Infof("SecurityGroupsClient.CreateOrUpdate(%q): end", *reconciledSg.Name)
+			az.nsgCache.invalidate(*reconciledSg.Name)
 			if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
 				glog.V(2).Infof("delete(%s) backing off: sg(%s) - updating", serviceName, az.SecurityGroupName)
 				retryErr := az.CreateOrUpdateSGWithRetry(reconciledSg)
@@ -355,6 +444,10 @@ func (az *Cloud) EnsureLoadBalancerDeleted(clusterName string, service *v1.Servi
 		}
 	}
 
+	if err := az.removeLoadBalancerFinalizer(service); err != nil {
+		return fmt.Errorf("delete(%s): failed to remove cleanup finalizer: %v", serviceName, err)
+	}
+
 	glog.V(2).Infof("delete(%s): FINISH", serviceName)
 	return nil
 }
@@ -394,11 +487,14 @@ func (az *Cloud) cleanupLoadBalancer(clusterName string, service *v1.Service, is
 			if len(*lb.FrontendIPConfigurations) > 0 {
 				glog.V(3).Infof("delete(%s): lb(%s) - updating", serviceName, lbName)
 				az.operationPollRateLimiter.Accept()
+				az.operationPool.acquire()
 				glog.V(10).Infof("LoadBalancerClient.CreateOrUpdate(%q): start", *lb.Name)
 				respChan, errChan := az.LoadBalancerClient.CreateOrUpdate(az.ResourceGroup, *lb.Name, lb, nil)
 				resp := <-respChan
 				err := <-errChan
+				az.operationPool.release()
 				glog.V(10).Infof("LoadBalancerClient.CreateOrUpdate(%q): end", *lb.Name)
+				az.lbCache.invalidate(*lb.Name)
 				if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
 					glog.V(2).Infof("delete(%s) backing off: sg(%s) - updating", serviceName, az.SecurityGroupName)
 					retryErr := az.CreateOrUpdateLBWithRetry(lb)
@@ -414,11 +510,14 @@ func (az *Cloud) cleanupLoadBalancer(clusterName string, service *v1.Service, is
 				glog.V(3).Infof("delete(%s): lb(%s) - deleting; no remaining frontendipconfigs", serviceName, lbName)
 
 				az.operationPollRateLimiter.Accept()
+				az.operationPool.acquire()
 				glog.V(10).Infof("LoadBalancerClient.Delete(%q): start", lbName)
 				respChan, errChan := az.LoadBalancerClient.Delete(az.ResourceGroup, lbName, nil)
 				resp := <-respChan
 				err := <-errChan
+				az.operationPool.release()
 				glog.V(10).Infof("LoadBalancerClient.Delete(%q): end", lbName)
+				az.lbCache.invalidate(lbName)
 				if az.CloudProviderBackoff && shouldRetryAPIRequest(resp, err) {
 					glog.V(2).Infof("delete(%s) backing off: lb(%s) - deleting; no remaining frontendipconfigs", serviceName, lbName)
 					retryErr := az.DeleteLBWithRetry(lbName)
@@ -455,7 +554,7 @@ func (az *Cloud) cleanupLoadBalancer(clusterName string, service *v1.Service, is
 	return nil
 }
 
-func (az *Cloud) ensurePublicIPExists(serviceName, pipName string) (*network.PublicIPAddress, error) {
+func (az *Cloud) ensurePublicIPExists(service *v1.Service, serviceName, pipName string) (*network.PublicIPAddress, error) {
 	pip, existsPip, err := az.getPublicIPAddress(pipName)
 	if err != nil {
 		return nil, err
@@ -469,15 +568,24 @@ func (az *Cloud) ensurePublicIPExists(serviceName, pipName string) (*network.Pub
 	pip.PublicIPAddressPropertiesFormat = &network.PublicIPAddressPropertiesFormat{
 		PublicIPAllocationMethod: network.Static,
 	}
-	pip.Tags = &map[string]*string{"service": &serviceName}
+	tags := mergeTags(az.Tags, service.Annotations[ServiceAnnotationLoadBalancerResourceTags])
+	if tags == nil {
+		tags = map[string]*string{}
+	}
+	tags["service"] = &serviceName
+	tags = az.addClusterNameTag(tags)
+	pip.Tags = &tags
 
 	glog.V(3).Infof("ensure(%s): pip(%s) - creating", serviceName, *pip.Name)
 	az.operationPollRateLimiter.Accept()
+	az.operationPool.acquire()
 	glog.V(10).Infof("PublicIPAddressesClient.CreateOrUpdate(%q): start", *pip.Name)
 	respChan, errChan := az.PublicIPAddressesClient.CreateOrUpdate(az.ResourceGroup, *pip.Name, pip, nil)
 	resp := <-respChan
 	err = <-errChan
+	az.operationPool.release()
 	glog.V(10).Infof("PublicIPAddressesClient.CreateOrUpdate(%q): end", *pip.Name)
+	az.publicIPCache.invalidate(*pip.Name)
 	if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
 		glog.V(2).Infof("ensure(%s) backing off: pip(%s) - creating", serviceName, *pip.Name)
 		retryErr := az.CreateOrUpdatePIPWithRetry(pip)
@@ -505,10 +613,13 @@ func (az *Cloud) ensurePublicIPExists(serviceName, pipName string) (*network.Pub
 func (az *Cloud) ensurePublicIPDeleted(serviceName, pipName string) error {
 	glog.V(2).Infof("ensure(%s): pip(%s) - deleting", serviceName, pipName)
 	az.operationPollRateLimiter.Accept()
+	az.operationPool.acquire()
 	glog.V(10).Infof("PublicIPAddressesClient.Delete(%q): start", pipName)
 	resp, deleteErrChan := az.PublicIPAddressesClient.Delete(az.ResourceGroup, pipName, nil)
 	deleteErr := <-deleteErrChan
+	az.operationPool.release()
 	glog.V(10).Infof("PublicIPAddressesClient.Delete(%q): end", pipName) // response not read yet...
+	az.publicIPCache.invalidate(pipName)
 	if az.CloudProviderBackoff && shouldRetryAPIRequest(<-resp, deleteErr) {
 		glog.V(2).Infof("ensure(%s) backing off: pip(%s) - deleting", serviceName, pipName)
 		retryErr := az.DeletePublicIPWithRetry(pipName)
@@ -642,6 +753,9 @@ func (az *Cloud) reconcileLoadBalancer(lb network.LoadBalancer, fipConfiguration
 			}
 
 			podPresencePath, podPresencePort := serviceapi.GetServiceHealthCheckPathPort(service)
+			if overridePort, ok := healthProbePortOverride(service); ok {
+				podPresencePort = overridePort
+			}
 
 			expectedProbes = append(expectedProbes, network.Probe{
 				Name: &lbRuleName,
@@ -655,11 +769,15 @@ func (az *Cloud) reconcileLoadBalancer(lb network.LoadBalancer, fipConfiguration
 			})
 		} else if port.Protocol != v1.ProtocolUDP {
 			// we only add the expected probe if we're doing TCP
+			probePort := port.NodePort
+			if overridePort, ok := healthProbePortOverride(service); ok {
+				probePort = overridePort
+			}
 			expectedProbes = append(expectedProbes, network.Probe{
 				Name: &lbRuleName,
 				ProbePropertiesFormat: &network.ProbePropertiesFormat{
 					Protocol:          *probeProto,
-					Port:              to.Int32Ptr(port.NodePort),
+					Port:              to.Int32Ptr(probePort),
 					IntervalInSeconds: to.Int32Ptr(5),
 					NumberOfProbes:    to.Int32Ptr(2),
 				},
@@ -695,6 +813,14 @@ func (az *Cloud) reconcileLoadBalancer(lb network.LoadBalancer, fipConfiguration
 			}
 		}
 
+		if az.enableTCPReset(service) {
+			// TODO: the vendored azure-sdk-for-go/arm/network in this tree predates the
+			// enableTCPReset property on LoadBalancingRulePropertiesFormat, so there's no way
+			// to actually apply the setting. Fail loudly instead of silently ignoring the
+			// annotation/config once the SDK is bumped this should set EnableTCPReset instead.
+			return lb, false, fmt.Errorf("reconcile(%s): %s is set (or EnableTCPReset defaults to true) but this build's Azure SDK does not support TCP reset on load balancing rules", serviceName, ServiceAnnotationLoadBalancerEnableTCPReset)
+		}
+
 		expectedRules = append(expectedRules, expectedRule)
 	}
 
@@ -786,6 +912,12 @@ func (az *Cloud) reconcileLoadBalancer(lb network.LoadBalancer, fipConfiguration
 // This entails adding required, missing SecurityRules and removing stale rules.
 func (az *Cloud) reconcileSecurityGroup(sg network.SecurityGroup, clusterName string, service *v1.Service, wantLb bool) (network.SecurityGroup, bool, error) {
 	serviceName := getServiceName(service)
+
+	if service.Annotations[ServiceAnnotationDisableSecurityGroupManagement] == "true" {
+		glog.V(3).Infof("reconcile(%s)(%t): sg - management disabled by annotation, leaving NSG untouched", serviceName, wantLb)
+		return sg, false, nil
+	}
+
 	var ports []v1.ServicePort
 	if wantLb {
 		ports = service.Spec.Ports
@@ -911,26 +1043,15 @@ func findSecurityRule(rules []network.SecurityRule, rule network.SecurityRule) b
 // This ensures the given VM's Primary NIC's Primary IP Configuration is
 // participating in the specified LoadBalancer Backend Pool.
 func (az *Cloud) ensureHostInPool(serviceName string, nodeName types.NodeName, backendPoolID string) error {
-	var machine compute.VirtualMachine
-	vmName := mapNodeNameToVMName(nodeName)
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("VirtualMachinesClient.Get(%q): start", vmName)
-	machine, err := az.VirtualMachinesClient.Get(az.ResourceGroup, vmName, "")
-	glog.V(10).Infof("VirtualMachinesClient.Get(%q): end", vmName)
+	machine, exists, err := az.getVirtualMachine(nodeName)
 	if err != nil {
-		if az.CloudProviderBackoff {
-			glog.V(2).Infof("ensureHostInPool(%s, %s, %s) backing off", serviceName, nodeName, backendPoolID)
-			machine, err = az.VirtualMachineClientGetWithRetry(az.ResourceGroup, vmName, "")
-			if err != nil {
-				glog.V(2).Infof("ensureHostInPool(%s, %s, %s) abort backoff", serviceName, nodeName, backendPoolID)
-				return err
-			}
-		} else {
-			return err
-		}
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("ensureHostInPool(%s, %s, %s): VM %q not found", serviceName, nodeName, backendPoolID, az.mapNodeNameToVMName(nodeName))
 	}
 
-	primaryNicID, err := getPrimaryInterfaceID(machine)
+	primaryNicID, err := az.getPrimaryInterfaceID(machine)
 	if err != nil {
 		return err
 	}
@@ -939,8 +1060,16 @@ func (az *Cloud) ensureHostInPool(serviceName string, nodeName types.NodeName, b
 		return err
 	}
 
-	// Check availability set
+	// Check availability set. VMSS-backed nodes and standalone VMs placed outside any
+	// availability set have a nil AvailabilitySet, so they never match a configured
+	// PrimaryAvailabilitySetName and are skipped rather than causing a nil dereference.
 	if az.PrimaryAvailabilitySetName != "" {
+		if machine.AvailabilitySet == nil || machine.AvailabilitySet.ID == nil {
+			glog.V(3).Infof(
+				"nicupdate(%s): skipping nic (%s) since the vm is not in any availability set, but primaryAvailabilitySet(%s) is configured",
+				serviceName, nicName, az.PrimaryAvailabilitySetName)
+			return nil
+		}
 		expectedAvailabilitySetName := az.getAvailabilitySetID(az.PrimaryAvailabilitySetName)
 		if !strings.EqualFold(*machine.AvailabilitySet.ID, expectedAvailabilitySetName) {
 			glog.V(3).Infof(
@@ -950,10 +1079,7 @@ func (az *Cloud) ensureHostInPool(serviceName string, nodeName types.NodeName, b
 		}
 	}
 
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("InterfacesClient.Get(%q): start", nicName)
-	nic, err := az.InterfacesClient.Get(az.ResourceGroup, nicName, "")
-	glog.V(10).Infof("InterfacesClient.Get(%q): end", nicName)
+	nic, err := az.getInterface(nicName)
 	if err != nil {
 		return err
 	}
@@ -985,11 +1111,14 @@ func (az *Cloud) ensureHostInPool(serviceName string, nodeName types.NodeName, b
 
 		glog.V(3).Infof("nicupdate(%s): nic(%s) - updating", serviceName, nicName)
 		az.operationPollRateLimiter.Accept()
+		az.operationPool.acquire()
 		glog.V(10).Infof("InterfacesClient.CreateOrUpdate(%q): start", *nic.Name)
 		respChan, errChan := az.InterfacesClient.CreateOrUpdate(az.ResourceGroup, *nic.Name, nic, nil)
 		resp := <-respChan
 		err := <-errChan
+		az.operationPool.release()
 		glog.V(10).Infof("InterfacesClient.CreateOrUpdate(%q): end", *nic.Name)
+		az.nicCache.invalidate(*nic.Name)
 		if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
 			glog.V(2).Infof("nicupdate(%s) backing off: nic(%s) - updating, err=%v", serviceName, nicName, err)
 			retryErr := az.CreateOrUpdateInterfaceWithRetry(nic)
@@ -1005,6 +1134,117 @@ func (az *Cloud) ensureHostInPool(serviceName string, nodeName types.NodeName, b
 	return nil
 }
 
+// enableTCPReset resolves the effective TCP reset setting for a service,
+// preferring the per-service annotation over the cluster-wide default.
+func (az *Cloud) enableTCPReset(service *v1.Service) bool {
+	if v, ok := service.Annotations[ServiceAnnotationLoadBalancerEnableTCPReset]; ok {
+		return v == "true"
+	}
+	return az.EnableTCPReset
+}
+
+// ensureLoadBalancerFinalizer adds LoadBalancerCleanupFinalizer to the service if it isn't
+// already present. If az.kubeClient is unset (e.g. in unit tests) this is a no-op.
+//
+// Retries on conflict, re-fetching the Service each attempt: this runs in the same reconcile
+// that also patches status.loadBalancer, so a concurrent write racing the finalizer update is
+// expected, not exceptional, and simply propagating a 409 here could leave the finalizer
+// never added - defeating its purpose of preventing orphaned PIPs.
+func (az *Cloud) ensureLoadBalancerFinalizer(service *v1.Service) error {
+	if az.kubeClient == nil || hasFinalizer(service, LoadBalancerCleanupFinalizer) {
+		return nil
+	}
+
+	services := az.kubeClient.CoreV1().Services(service.Namespace)
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		current, err := services.Get(service.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if hasFinalizer(current, LoadBalancerCleanupFinalizer) {
+			return nil
+		}
+		current.Finalizers = append(current.Finalizers, LoadBalancerCleanupFinalizer)
+		_, err = services.Update(current)
+		return err
+	})
+}
+
+// removeLoadBalancerFinalizer removes LoadBalancerCleanupFinalizer once all LB/PIP/NSG
+// resources for the service have been cleaned up, allowing the Service object to be
+// garbage collected by the API server.
+//
+// Retries on conflict, re-fetching the Service each attempt; see ensureLoadBalancerFinalizer.
+// Here a propagated 409 could instead leave the finalizer stuck forever, since nothing else
+// retries removing it once EnsureLoadBalancerDeleted returns.
+func (az *Cloud) removeLoadBalancerFinalizer(service *v1.Service) error {
+	if az.kubeClient == nil || !hasFinalizer(service, LoadBalancerCleanupFinalizer) {
+		return nil
+	}
+
+	services := az.kubeClient.CoreV1().Services(service.Namespace)
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		current, err := services.Get(service.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !hasFinalizer(current, LoadBalancerCleanupFinalizer) {
+			return nil
+		}
+		finalizers := make([]string, 0, len(current.Finalizers))
+		for _, f := range current.Finalizers {
+			if f != LoadBalancerCleanupFinalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		current.Finalizers = finalizers
+		_, err = services.Update(current)
+		return err
+	})
+}
+
+// excludeExternallyManagedNodes drops nodes carrying the Config.ExcludeNodesLabel
+// key=value pair from the backend pool candidate list, so that bare-metal or other-cloud
+// nodes joined to the cluster are never added to (or removed from) an Azure LB.
+func (az *Cloud) excludeExternallyManagedNodes(nodes []*v1.Node) []*v1.Node {
+	if az.ExcludeNodesLabel == "" {
+		return nodes
+	}
+	filtered := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if nodeLabelMatches(node.Labels, az.ExcludeNodesLabel) {
+			glog.V(3).Infof("excludeExternallyManagedNodes: node %q carries %q, excluding from LB backend pool", node.Name, az.ExcludeNodesLabel)
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+	return filtered
+}
+
+func hasFinalizer(service *v1.Service, finalizer string) bool {
+	for _, f := range service.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// healthProbePortOverride returns the port from ServiceAnnotationHealthProbePort, if the
+// service sets one and it parses as a valid port number.
+func healthProbePortOverride(service *v1.Service) (int32, bool) {
+	v, ok := service.Annotations[ServiceAnnotationHealthProbePort]
+	if !ok {
+		return 0, false
+	}
+	port, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		glog.Warningf("healthProbePortOverride(%s): invalid %s value %q: %v", getServiceName(service), ServiceAnnotationHealthProbePort, v, err)
+		return 0, false
+	}
+	return int32(port), true
+}
+
 // Check if service requires an internal load balancer.
 func requiresInternalLoadBalancer(service *v1.Service) bool {
 	if l, ok := service.Annotations[ServiceAnnotationLoadBalancerInternal]; ok {
@@ -0,0 +1,165 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func conflictError(name string) error {
+	return apierrors.NewConflict(schema.GroupResource{Resource: "services"}, name, fmt.Errorf("concurrent update"))
+}
+
+func TestEnsureLoadBalancerFinalizerAddsFinalizer(t *testing.T) {
+	az := getTestCloud()
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"}}
+	az.kubeClient = fake.NewSimpleClientset(svc)
+
+	if err := az.ensureLoadBalancerFinalizer(svc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	updated, err := az.kubeClient.CoreV1().Services("default").Get("svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error fetching service: %v", err)
+	}
+	if !hasFinalizer(updated, LoadBalancerCleanupFinalizer) {
+		t.Errorf("expected %q to be added, got finalizers %v", LoadBalancerCleanupFinalizer, updated.Finalizers)
+	}
+}
+
+func TestEnsureLoadBalancerFinalizerNoopWhenAlreadyPresent(t *testing.T) {
+	az := getTestCloud()
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc", Finalizers: []string{LoadBalancerCleanupFinalizer}}}
+	az.kubeClient = fake.NewSimpleClientset(svc)
+
+	if err := az.ensureLoadBalancerFinalizer(svc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The service passed in already carries the finalizer, so ensureLoadBalancerFinalizer
+	// should short-circuit without ever calling Update.
+	for _, action := range az.kubeClient.(*fake.Clientset).Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("unexpected update action %v when finalizer was already present", action)
+		}
+	}
+}
+
+func TestEnsureLoadBalancerFinalizerRetriesOnConflict(t *testing.T) {
+	az := getTestCloud()
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"}}
+	client := fake.NewSimpleClientset(svc)
+
+	conflicts := 2
+	client.PrependReactor("update", "services", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, conflictError("svc")
+		}
+		return false, nil, nil
+	})
+	az.kubeClient = client
+
+	if err := az.ensureLoadBalancerFinalizer(svc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conflicts != 0 {
+		t.Errorf("got %d unconsumed conflicts, want 0", conflicts)
+	}
+
+	updated, err := az.kubeClient.CoreV1().Services("default").Get("svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error fetching service: %v", err)
+	}
+	if !hasFinalizer(updated, LoadBalancerCleanupFinalizer) {
+		t.Errorf("expected %q to be added after retrying past conflicts, got finalizers %v", LoadBalancerCleanupFinalizer, updated.Finalizers)
+	}
+}
+
+func TestRemoveLoadBalancerFinalizerRemovesFinalizer(t *testing.T) {
+	az := getTestCloud()
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc", Finalizers: []string{"other-finalizer", LoadBalancerCleanupFinalizer}}}
+	az.kubeClient = fake.NewSimpleClientset(svc)
+
+	if err := az.removeLoadBalancerFinalizer(svc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	updated, err := az.kubeClient.CoreV1().Services("default").Get("svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error fetching service: %v", err)
+	}
+	if hasFinalizer(updated, LoadBalancerCleanupFinalizer) {
+		t.Errorf("expected %q to be removed, got finalizers %v", LoadBalancerCleanupFinalizer, updated.Finalizers)
+	}
+	if !hasFinalizer(updated, "other-finalizer") {
+		t.Errorf("expected unrelated finalizer to survive, got finalizers %v", updated.Finalizers)
+	}
+}
+
+func TestRemoveLoadBalancerFinalizerRetriesOnConflict(t *testing.T) {
+	az := getTestCloud()
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc", Finalizers: []string{LoadBalancerCleanupFinalizer}}}
+	client := fake.NewSimpleClientset(svc)
+
+	conflicts := 2
+	client.PrependReactor("update", "services", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, conflictError("svc")
+		}
+		return false, nil, nil
+	})
+	az.kubeClient = client
+
+	if err := az.removeLoadBalancerFinalizer(svc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conflicts != 0 {
+		t.Errorf("got %d unconsumed conflicts, want 0", conflicts)
+	}
+
+	updated, err := az.kubeClient.CoreV1().Services("default").Get("svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error fetching service: %v", err)
+	}
+	if hasFinalizer(updated, LoadBalancerCleanupFinalizer) {
+		t.Errorf("expected %q to be removed after retrying past conflicts, got finalizers %v", LoadBalancerCleanupFinalizer, updated.Finalizers)
+	}
+}
+
+func TestFinalizerHelpersNoopWithoutKubeClient(t *testing.T) {
+	az := getTestCloud()
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"}}
+
+	if err := az.ensureLoadBalancerFinalizer(svc); err != nil {
+		t.Errorf("unexpected error with no kubeClient: %v", err)
+	}
+	if err := az.removeLoadBalancerFinalizer(svc); err != nil {
+		t.Errorf("unexpected error with no kubeClient: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+)
+
+// opLogger emits glog lines carrying a consistent set of key=value fields - operation, node,
+// diskURI, and a per-call requestID - so a disk-controller failure can be grepped and
+// correlated across a support escalation instead of matched by hand against free-form message
+// text.
+//
+// requestID here is a locally-minted per-invocation token, not ARM's x-ms-request-id: the
+// generated disk/compute/network clients return results over a (chan Response, chan error)
+// pair rather than the *http.Response itself, so the ARM request ID isn't available at this
+// layer. It's already captured separately, per HTTP round trip, by correlationIDSender in
+// azure_correlation.go; cross-reference the two by timestamp when a support case needs both.
+type opLogger struct {
+	operation string
+	node      string
+	diskURI   string
+	requestID string
+}
+
+var opCounter uint64
+
+// forOperation starts a new opLogger scoped to a single call, minting a fresh requestID, e.g.
+// forOperation("AttachDisk").withNode(nodeName).withDiskURI(diskURI).
+func forOperation(operation string) opLogger {
+	id := atomic.AddUint64(&opCounter, 1)
+	return opLogger{operation: operation, requestID: fmt.Sprintf("op-%d", id)}
+}
+
+func (l opLogger) withNode(node string) opLogger {
+	l.node = node
+	return l
+}
+
+func (l opLogger) withDiskURI(diskURI string) opLogger {
+	l.diskURI = diskURI
+	return l
+}
+
+func (l opLogger) fields() string {
+	return fmt.Sprintf("op=%s node=%s diskURI=%s requestID=%s", l.operation, l.node, l.diskURI, l.requestID)
+}
+
+func (l opLogger) V(level glog.Level, format string, args ...interface{}) {
+	glog.V(level).Infof("%s msg=%q", l.fields(), fmt.Sprintf(format, args...))
+}
+
+func (l opLogger) Errorf(format string, args ...interface{}) {
+	glog.Errorf("%s msg=%q", l.fields(), fmt.Sprintf(format, args...))
+}
+
+func (l opLogger) Warningf(format string, args ...interface{}) {
+	glog.Warningf("%s msg=%q", l.fields(), fmt.Sprintf(format, args...))
+}
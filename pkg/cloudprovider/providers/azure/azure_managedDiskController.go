@@ -22,7 +22,6 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/arm/disk"
 	storage "github.com/Azure/azure-sdk-for-go/arm/storage"
-	"github.com/golang/glog"
 	kwait "k8s.io/apimachinery/pkg/util/wait"
 )
 
@@ -37,7 +36,8 @@ func newManagedDiskController(common *controllerCommon) (*ManagedDiskController,
 
 //CreateManagedDisk : create managed disk
 func (c *ManagedDiskController) CreateManagedDisk(diskName string, storageAccountType storage.SkuName, sizeGB int, tags map[string]string) (string, error) {
-	glog.V(4).Infof("azureDisk - creating new managed Name:%s StorageAccountType:%s Size:%v", diskName, storageAccountType, sizeGB)
+	logger := forOperation("CreateManagedDisk")
+	logger.V(4, "creating new managed disk %s StorageAccountType:%s Size:%v", diskName, storageAccountType, sizeGB)
 
 	newTags := make(map[string]*string)
 	azureDDTag := "kubernetes-azure-dd"
@@ -52,6 +52,7 @@ func (c *ManagedDiskController) CreateManagedDisk(diskName string, storageAccoun
 			newTags[newKey] = &newValue
 		}
 	}
+	newTags = c.common.cloud.addClusterNameTag(newTags)
 
 	diskSizeGB := int32(sizeGB)
 	model := disk.Model{
@@ -63,16 +64,18 @@ func (c *ManagedDiskController) CreateManagedDisk(diskName string, storageAccoun
 			CreationData: &disk.CreationData{CreateOption: disk.Empty},
 		}}
 	cancel := make(chan struct{})
+	c.common.cloud.operationPool.acquire()
 	respChan, errChan := c.common.cloud.DisksClient.CreateOrUpdate(c.common.resourceGroup, diskName, model, cancel)
 	<-respChan
 	err := <-errChan
+	c.common.cloud.operationPool.release()
 	if err != nil {
 		return "", err
 	}
 
 	diskID := ""
 
-	err = kwait.ExponentialBackoff(defaultBackOff, func() (bool, error) {
+	err = kwait.ExponentialBackoff(c.common.cloud.resourceRequestBackoff, func() (bool, error) {
 		provisonState, id, err := c.getDisk(diskName)
 		diskID = id
 		// We are waiting for provisioningState==Succeeded
@@ -87,10 +90,11 @@ func (c *ManagedDiskController) CreateManagedDisk(diskName string, storageAccoun
 		return false, nil
 	})
 
+	logger = logger.withDiskURI(diskID)
 	if err != nil {
-		glog.V(2).Infof("azureDisk - created new MD Name:%s StorageAccountType:%s Size:%v but was unable to confirm provisioningState in poll process", diskName, storageAccountType, sizeGB)
+		logger.V(2, "created new managed disk %s StorageAccountType:%s Size:%v but was unable to confirm provisioningState in poll process", diskName, storageAccountType, sizeGB)
 	} else {
-		glog.V(2).Infof("azureDisk - created new MD Name:%s StorageAccountType:%s Size:%v", diskName, storageAccountType, sizeGB)
+		logger.V(2, "created new managed disk %s StorageAccountType:%s Size:%v", diskName, storageAccountType, sizeGB)
 	}
 
 	return diskID, nil
@@ -98,18 +102,21 @@ func (c *ManagedDiskController) CreateManagedDisk(diskName string, storageAccoun
 
 //DeleteManagedDisk : delete managed disk
 func (c *ManagedDiskController) DeleteManagedDisk(diskURI string) error {
+	logger := forOperation("DeleteManagedDisk").withDiskURI(diskURI)
 	diskName := path.Base(diskURI)
 	cancel := make(chan struct{})
+	c.common.cloud.operationPool.acquire()
 	respChan, errChan := c.common.cloud.DisksClient.Delete(c.common.resourceGroup, diskName, cancel)
 	<-respChan
 	err := <-errChan
+	c.common.cloud.operationPool.release()
 	if err != nil {
 		return err
 	}
 	// We don't need poll here, k8s will immediatly stop referencing the disk
 	// the disk will be evantually deleted - cleanly - by ARM
 
-	glog.V(2).Infof("azureDisk - deleted a managed disk: %s", diskURI)
+	logger.V(2, "deleted a managed disk: %s", diskURI)
 
 	return nil
 }
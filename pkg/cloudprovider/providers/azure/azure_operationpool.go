@@ -0,0 +1,42 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+// operationPool bounds how many long-running ARM operations this process waits on at once.
+// Every CreateOrUpdate/Delete call against the generated clients returns a (chan Response,
+// chan error) pair that the generated client itself resolves on a dedicated goroutine polling
+// the operation's Azure-Asyncoperation URL until it completes; without a shared limit, a
+// reconciliation storm across many Services/PVCs/Nodes spawns one such poller per call, all
+// hammering the same handful of ARM endpoints at once. Callers acquire a slot before starting
+// the operation and release it once its result channels resolve.
+type operationPool struct {
+	tokens chan struct{}
+}
+
+func newOperationPool(maxConcurrent int) *operationPool {
+	return &operationPool{tokens: make(chan struct{}, maxConcurrent)}
+}
+
+// acquire blocks until a poller slot is free.
+func (p *operationPool) acquire() {
+	p.tokens <- struct{}{}
+}
+
+// release frees the poller slot acquired by acquire.
+func (p *operationPool) release() {
+	<-p.tokens
+}
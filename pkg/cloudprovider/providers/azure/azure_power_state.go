@@ -0,0 +1,116 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/compute"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// powerStateCache holds the last power state observed for each VM in the resource group,
+// refreshed in the background by startPowerStatePoller so InstanceShutdownByProviderID can
+// answer from memory instead of blocking the node controller's reconcile loop on an ARM call
+// per node every sync.
+type powerStateCache struct {
+	mutex    sync.RWMutex
+	byVMName map[string]string
+}
+
+func newPowerStateCache() *powerStateCache {
+	return &powerStateCache{byVMName: map[string]string{}}
+}
+
+func (c *powerStateCache) get(vmName string) (state string, found bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	state, found = c.byVMName[vmName]
+	return state, found
+}
+
+func (c *powerStateCache) set(vmName, state string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.byVMName[vmName] = state
+}
+
+func (c *powerStateCache) delete(vmName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.byVMName, vmName)
+}
+
+// startPowerStatePoller runs a background loop for the lifetime of the process, listing
+// every VM's instance view once per interval and recording its power state, so shutdown
+// detection can be near-instant instead of waiting on a live per-node ARM round-trip.
+func (az *Cloud) startPowerStatePoller(interval time.Duration) {
+	az.powerState = newPowerStateCache()
+	go wait.Until(az.pollPowerStates, interval, wait.NeverStop)
+}
+
+// pollPowerStates lists every VM in the resource group, then fetches each one's instance
+// view (the List API doesn't include it) to record its current power state. This is still
+// one ARM call per VM, but it happens on a timer in the background instead of on the node
+// controller's synchronous reconcile path.
+func (az *Cloud) pollPowerStates() {
+	nodes, err := az.listAllNodesInResourceGroup()
+	if err != nil {
+		glog.Errorf("azure: power state poller could not list VMs: %v", err)
+		return
+	}
+
+	for _, node := range nodes {
+		if node.Name == nil {
+			continue
+		}
+		vmName := *node.Name
+
+		az.operationPollRateLimiter.Accept()
+		vm, err := az.VirtualMachinesClient.Get(az.ResourceGroup, vmName, compute.InstanceView)
+		if err != nil {
+			glog.V(4).Infof("azure: power state poller could not get instance view for %s: %v", vmName, err)
+			continue
+		}
+		if vm.VirtualMachineProperties == nil || vm.InstanceView == nil || vm.InstanceView.Statuses == nil {
+			continue
+		}
+		for _, status := range *vm.InstanceView.Statuses {
+			if status.Code == nil {
+				continue
+			}
+			if strings.HasPrefix(*status.Code, "PowerState/") {
+				az.powerState.set(vmName, *status.Code)
+				break
+			}
+		}
+	}
+}
+
+// powerStateIsShutdown reports whether a PowerState/... status code represents a stopped or
+// deallocated VM. Shared by the poller-backed and live-lookup paths in
+// InstanceShutdownByProviderID so both apply the same set of terminal states.
+func powerStateIsShutdown(code string) bool {
+	switch code {
+	case "PowerState/stopped", "PowerState/deallocated", "PowerState/deallocating":
+		return true
+	}
+	return false
+}
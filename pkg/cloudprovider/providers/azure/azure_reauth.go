@@ -0,0 +1,120 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/golang/glog"
+)
+
+// reauthMinInterval bounds how often reauthenticatingSender will actually call out to AAD for a
+// fresh token, so a burst of concurrently in-flight requests that all hit a 401 at once triggers
+// one re-acquisition instead of one per request.
+const reauthMinInterval = 30 * time.Second
+
+// reauthenticatingSender wraps an autorest.Sender and, on a 401 response, re-acquires an AAD
+// token from scratch (rather than relying on the token's own refresh token, which is useless if
+// the SP's secret was rotated) and retries the request once with the new Authorization header.
+type reauthenticatingSender struct {
+	next       autorest.Sender
+	az         *Cloud
+	authorizer *reloadingAuthorizer
+
+	mu         sync.Mutex
+	lastReauth time.Time
+}
+
+func (s *reauthenticatingSender) Do(req *http.Request) (*http.Response, error) {
+	resp, err := s.next.Do(req)
+	if err != nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	retryReq, cloneErr := cloneRequestForRetry(req)
+	if cloneErr != nil {
+		glog.V(2).Infof("azure: got 401 from %s but could not clone the request to retry it: %v", req.URL, cloneErr)
+		return resp, err
+	}
+
+	glog.V(2).Infof("azure: got 401 from %s, re-acquiring an AAD token and retrying once", req.URL)
+	if reauthErr := s.reauthenticate(); reauthErr != nil {
+		glog.Errorf("azure: failed to re-acquire an AAD token after a 401: %v", reauthErr)
+		return resp, err
+	}
+	resp.Body.Close()
+
+	preparedReq, prepErr := autorest.Prepare(retryReq, s.authorizer.WithAuthorization())
+	if prepErr != nil {
+		glog.Errorf("azure: failed to re-authorize the retried request: %v", prepErr)
+		return resp, err
+	}
+
+	return s.next.Do(preparedReq)
+}
+
+// reauthenticate builds a fresh service principal token from az.Config and swaps it into
+// authorizer, unless another request already did so within reauthMinInterval.
+func (s *reauthenticatingSender) reauthenticate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastReauth) < reauthMinInterval {
+		// Assume a concurrent request already refreshed the token; the caller retries with
+		// whatever the authorizer currently holds.
+		return nil
+	}
+
+	token, err := GetServicePrincipalToken(&s.az.Config, &s.az.Environment)
+	if err != nil {
+		return err
+	}
+	s.authorizer.setInner(autorest.NewBearerAuthorizer(token))
+	s.lastReauth = time.Now()
+	return nil
+}
+
+// cloneRequestForRetry copies req well enough to safely send it a second time: a shallow copy
+// plus a fresh Header map (so mutating the retry's headers, e.g. re-authorizing it, doesn't
+// affect the original) and a rewound body sourced from GetBody, since the original Body was
+// already consumed by the first attempt.
+func cloneRequestForRetry(req *http.Request) (*http.Request, error) {
+	clone := new(http.Request)
+	*clone = *req
+
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string(nil), v...)
+	}
+
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("request has a body but no GetBody, can't safely retry it")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+
+	return clone, nil
+}
@@ -18,7 +18,11 @@ package azure
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/kubernetes/pkg/cloudprovider"
 
 	"github.com/Azure/azure-sdk-for-go/arm/network"
@@ -27,88 +31,288 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 )
 
-// ListRoutes lists all managed routes that belong to the specified clusterName
-func (az *Cloud) ListRoutes(clusterName string) (routes []*cloudprovider.Route, err error) {
-	glog.V(10).Infof("list: START clusterName=%q", clusterName)
-	routeTable, existsRouteTable, err := az.getRouteTable()
+// routeUpdateInterval is how long pending CreateRoute/DeleteRoute calls are coalesced
+// before being applied as a single route-table PUT. In clusters with hundreds of nodes
+// churning at once, one PUT per route change serializes badly and gets throttled; batching
+// them keeps the number of ARM calls roughly constant regardless of node count.
+const routeUpdateInterval = 3 * time.Second
+
+// routeOperation is a single pending change to be folded into the next batched route-table update.
+type routeOperation struct {
+	route     network.Route
+	isDeleted bool
+	result    chan error
+}
+
+// routeUpdater coalesces concurrent CreateRoute/DeleteRoute calls targeting a single route
+// table into batched route-table PUTs. One is created lazily per route table name and
+// lives for the process lifetime of the Cloud.
+type routeUpdater struct {
+	az            *Cloud
+	routeTableName string
+	interval      time.Duration
+
+	mu      sync.Mutex
+	pending []routeOperation
+}
+
+// getRouteUpdater returns the routeUpdater responsible for routeTableName, creating it
+// (and its background flush loop) on first use.
+func (az *Cloud) getRouteUpdater(routeTableName string) *routeUpdater {
+	az.routeUpdatersMu.Lock()
+	defer az.routeUpdatersMu.Unlock()
+
+	if az.routeUpdaters == nil {
+		az.routeUpdaters = make(map[string]*routeUpdater)
+	}
+	u, ok := az.routeUpdaters[routeTableName]
+	if !ok {
+		u = &routeUpdater{az: az, routeTableName: routeTableName, interval: routeUpdateInterval}
+		az.routeUpdaters[routeTableName] = u
+		go u.run()
+	}
+	return u
+}
+
+// nodeExcludedFromRouteReconciliation reports whether the node carries the
+// Config.ExcludeCNINodesLabel key=value pair (it manages its own pod routing, e.g. via
+// Azure CNI) or the Config.ExcludeNodesLabel key=value pair (it is externally managed and
+// should not get an entry in the route table at all).
+func (az *Cloud) nodeExcludedFromRouteReconciliation(nodeName types.NodeName) bool {
+	if az.ExcludeCNINodesLabel == "" && az.ExcludeNodesLabel == "" {
+		return false
+	}
+	if az.kubeClient == nil {
+		return false
+	}
+
+	node, err := az.kubeClient.CoreV1().Nodes().Get(string(nodeName), metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		glog.V(4).Infof("nodeExcludedFromRouteReconciliation: could not get node %q: %v", nodeName, err)
+		return false
 	}
-	if !existsRouteTable {
-		return []*cloudprovider.Route{}, nil
+	return nodeLabelMatches(node.Labels, az.ExcludeCNINodesLabel) || nodeLabelMatches(node.Labels, az.ExcludeNodesLabel)
+}
+
+// routeTableNameForNode resolves which route table a node's pod CIDR routes belong in,
+// consulting Config.RouteTables (keyed by availability set / agent pool name) and falling
+// back to the cluster-wide RouteTableName when the node's pool isn't mapped.
+func (az *Cloud) routeTableNameForNode(nodeName types.NodeName) string {
+	if len(az.RouteTables) == 0 {
+		return az.RouteTableName
 	}
 
-	var kubeRoutes []*cloudprovider.Route
-	if routeTable.Routes != nil {
-		kubeRoutes = make([]*cloudprovider.Route, len(*routeTable.Routes))
-		for i, route := range *routeTable.Routes {
-			instance := mapRouteNameToNodeName(*route.Name)
-			cidr := *route.AddressPrefix
-			glog.V(10).Infof("list: * instance=%q, cidr=%q", instance, cidr)
+	vm, exists, err := az.getVirtualMachine(nodeName)
+	if err != nil || !exists || vm.AvailabilitySet == nil || vm.AvailabilitySet.ID == nil {
+		return az.RouteTableName
+	}
+	asName, err := getLastSegment(*vm.AvailabilitySet.ID)
+	if err != nil {
+		return az.RouteTableName
+	}
+	if table, ok := az.RouteTables[asName]; ok && table != "" {
+		return table
+	}
+	return az.RouteTableName
+}
 
-			kubeRoutes[i] = &cloudprovider.Route{
-				Name:            *route.Name,
-				TargetNode:      instance,
-				DestinationCIDR: cidr,
-			}
-		}
+// enqueue submits a route change and blocks until it has been applied (or failed) as part
+// of a batch, returning the error observed for the batched route-table update.
+func (u *routeUpdater) enqueue(op routeOperation) error {
+	op.result = make(chan error, 1)
+	u.mu.Lock()
+	u.pending = append(u.pending, op)
+	u.mu.Unlock()
+	return <-op.result
+}
+
+func (u *routeUpdater) run() {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		u.flush()
 	}
+}
 
-	glog.V(10).Info("list: FINISH")
-	return kubeRoutes, nil
+func (u *routeUpdater) flush() {
+	u.mu.Lock()
+	ops := u.pending
+	u.pending = nil
+	u.mu.Unlock()
+
+	if len(ops) == 0 {
+		return
+	}
+
+	err := u.applyBatch(ops)
+	for _, op := range ops {
+		op.result <- err
+	}
 }
 
-// CreateRoute creates the described managed route
-// route.Name will be ignored, although the cloud-provider may use nameHint
-// to create a more user-meaningful name.
-func (az *Cloud) CreateRoute(clusterName string, nameHint string, kubeRoute *cloudprovider.Route) error {
-	glog.V(2).Infof("create: creating route. clusterName=%q instance=%q cidr=%q", clusterName, kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
+// applyBatch folds all pending route operations into a single route-table object and
+// issues one CreateOrUpdate. When CloudProviderBackoff is enabled, a failed write (an error
+// or a 4xx/5xx response, a conflict included) is retried with backoff through
+// CreateOrUpdateRouteTableWithRetry, which resends the same merged table: the route table
+// is not re-read and the operations are not re-applied to a newer copy before a retry.
+func (u *routeUpdater) applyBatch(ops []routeOperation) error {
+	az := u.az
 
-	routeTable, existsRouteTable, err := az.getRouteTable()
+	routeTable, existsRouteTable, err := az.getRouteTableByName(u.routeTableName)
 	if err != nil {
-		glog.V(2).Infof("create error: couldn't get routetable. clusterName=%q instance=%q cidr=%q", clusterName, kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
 		return err
 	}
 	if !existsRouteTable {
 		routeTable = network.RouteTable{
-			Name:                       to.StringPtr(az.RouteTableName),
+			Name:                       to.StringPtr(u.routeTableName),
 			Location:                   to.StringPtr(az.Location),
 			RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{},
 		}
+	}
+	if tags := az.addClusterNameTag(nil); tags != nil && !tagsEqual(routeTable.Tags, tags) {
+		routeTable.Tags = &tags
+	}
+
+	var existingRoutes []network.Route
+	if routeTable.Routes != nil {
+		existingRoutes = *routeTable.Routes
+	}
+	routes, changed := mergeRouteOperations(existingRoutes, ops)
+
+	if !changed {
+		glog.V(4).Infof("routeUpdater: batch of %d op(s) against routetable %q is a no-op, skipping write", len(ops), u.routeTableName)
+		return nil
+	}
+	routeTable.Routes = &routes
+
+	glog.V(3).Infof("routeUpdater: applying batch of %d route change(s) to routetable %q", len(ops), u.routeTableName)
+	az.operationPollRateLimiter.Accept()
+	respChan, errChan := az.RouteTablesClient.CreateOrUpdate(az.ResourceGroup, u.routeTableName, routeTable, nil)
+	resp := <-respChan
+	err = <-errChan
+	if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
+		glog.V(2).Infof("routeUpdater: backing off batch update to routetable %q", u.routeTableName)
+		err = az.CreateOrUpdateRouteTableWithRetry(routeTable)
+	}
+	return err
+}
+
+// mergeRouteOperations applies ops on top of existingRoutes and returns the resulting route
+// list plus whether it differs from existingRoutes. Split out of applyBatch as a pure
+// function, with no Cloud/ARM dependency, so the dedup-by-name-wins batching logic can be
+// unit tested directly.
+func mergeRouteOperations(existingRoutes []network.Route, ops []routeOperation) ([]network.Route, bool) {
+	// Later operations on the same route name win over earlier ones queued in the same
+	// batch, e.g. a delete immediately following a stale create.
+	dedupedOps := make(map[string]routeOperation, len(ops))
+	var order []string
+	for _, op := range ops {
+		if op.route.Name == nil {
+			continue
+		}
+		if _, seen := dedupedOps[*op.route.Name]; !seen {
+			order = append(order, *op.route.Name)
+		}
+		dedupedOps[*op.route.Name] = op
+	}
 
-		glog.V(3).Infof("create: creating routetable. routeTableName=%q", az.RouteTableName)
-		az.operationPollRateLimiter.Accept()
-		glog.V(10).Infof("RouteTablesClient.CreateOrUpdate(%q): start", az.RouteTableName)
-		respChan, errChan := az.RouteTablesClient.CreateOrUpdate(az.ResourceGroup, az.RouteTableName, routeTable, nil)
-		resp := <-respChan
-		err := <-errChan
-		glog.V(10).Infof("RouteTablesClient.CreateOrUpdate(%q): end", az.RouteTableName)
-		if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
-			glog.V(2).Infof("create backing off: creating routetable. routeTableName=%q", az.RouteTableName)
-			retryErr := az.CreateOrUpdateRouteTableWithRetry(routeTable)
-			if retryErr != nil {
-				err = retryErr
-				glog.V(2).Infof("create abort backoff: creating routetable. routeTableName=%q", az.RouteTableName)
+	routes := existingRoutes
+	changed := false
+	for _, name := range order {
+		op := dedupedOps[name]
+		idx := -1
+		for i, r := range routes {
+			if r.Name != nil && *r.Name == name {
+				idx = i
+				break
 			}
 		}
-		if err != nil {
-			return err
+		switch {
+		case op.isDeleted && idx >= 0:
+			routes = append(routes[:idx], routes[idx+1:]...)
+			changed = true
+		case !op.isDeleted && idx >= 0:
+			if !routeEqual(routes[idx], op.route) {
+				routes[idx] = op.route
+				changed = true
+			}
+		case !op.isDeleted && idx < 0:
+			routes = append(routes, op.route)
+			changed = true
 		}
+	}
+	return routes, changed
+}
+
+// routeEqual reports whether two routes have the same effective ARM properties, so
+// applyBatch can avoid writing back a route that hasn't actually changed.
+func routeEqual(a, b network.Route) bool {
+	if a.RoutePropertiesFormat == nil || b.RoutePropertiesFormat == nil {
+		return a.RoutePropertiesFormat == b.RoutePropertiesFormat
+	}
+	return to.String(a.AddressPrefix) == to.String(b.AddressPrefix) &&
+		to.String(a.NextHopIPAddress) == to.String(b.NextHopIPAddress) &&
+		a.NextHopType == b.NextHopType
+}
 
-		glog.V(10).Infof("RouteTablesClient.Get(%q): start", az.RouteTableName)
-		routeTable, err = az.RouteTablesClient.Get(az.ResourceGroup, az.RouteTableName, "")
-		glog.V(10).Infof("RouteTablesClient.Get(%q): end", az.RouteTableName)
+// ListRoutes lists all managed routes that belong to the specified clusterName
+func (az *Cloud) ListRoutes(clusterName string) (routes []*cloudprovider.Route, err error) {
+	glog.V(10).Infof("list: START clusterName=%q", clusterName)
+
+	// Multi-route-table clusters have pod CIDR routes spread across az.RouteTableName plus
+	// every table named in Config.RouteTables; list them all.
+	tableNames := map[string]bool{az.RouteTableName: true}
+	for _, name := range az.RouteTables {
+		if name != "" {
+			tableNames[name] = true
+		}
+	}
+
+	var kubeRoutes []*cloudprovider.Route
+	for tableName := range tableNames {
+		routeTable, existsRouteTable, err := az.getRouteTableByName(tableName)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if !existsRouteTable || routeTable.Routes == nil {
+			continue
+		}
+		for _, route := range *routeTable.Routes {
+			instance := mapRouteNameToNodeName(*route.Name)
+			cidr := *route.AddressPrefix
+			glog.V(10).Infof("list: * instance=%q, cidr=%q, routetable=%q", instance, cidr, tableName)
+
+			kubeRoutes = append(kubeRoutes, &cloudprovider.Route{
+				Name:            *route.Name,
+				TargetNode:      instance,
+				DestinationCIDR: cidr,
+			})
 		}
 	}
 
+	glog.V(10).Info("list: FINISH")
+	return kubeRoutes, nil
+}
+
+// CreateRoute creates the described managed route
+// route.Name will be ignored, although the cloud-provider may use nameHint
+// to create a more user-meaningful name.
+// CreateRoute does not issue its own route-table PUT; it enqueues the change with the
+// Cloud's routeUpdater, which folds concurrent create/delete calls into batched updates.
+func (az *Cloud) CreateRoute(clusterName string, nameHint string, kubeRoute *cloudprovider.Route) error {
+	glog.V(2).Infof("create: creating route. clusterName=%q instance=%q cidr=%q", clusterName, kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
+
+	if az.nodeExcludedFromRouteReconciliation(kubeRoute.TargetNode) {
+		glog.V(3).Infof("create: instance=%q carries %q, skipping route programming", kubeRoute.TargetNode, az.ExcludeCNINodesLabel)
+		return nil
+	}
+
 	targetIP, err := az.getIPForMachine(kubeRoute.TargetNode)
 	if err != nil {
 		return err
 	}
 
-	routeName := mapNodeNameToRouteName(kubeRoute.TargetNode)
+	routeName := mapNodeNameToRouteName(kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
 	route := network.Route{
 		Name: to.StringPtr(routeName),
 		RoutePropertiesFormat: &network.RoutePropertiesFormat{
@@ -118,22 +322,8 @@ func (az *Cloud) CreateRoute(clusterName string, nameHint string, kubeRoute *clo
 		},
 	}
 
-	glog.V(3).Infof("create: creating route: instance=%q cidr=%q", kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("RoutesClient.CreateOrUpdate(%q): start", az.RouteTableName)
-	respChan, errChan := az.RoutesClient.CreateOrUpdate(az.ResourceGroup, az.RouteTableName, *route.Name, route, nil)
-	resp := <-respChan
-	err = <-errChan
-	glog.V(10).Infof("RoutesClient.CreateOrUpdate(%q): end", az.RouteTableName)
-	if az.CloudProviderBackoff && shouldRetryAPIRequest(resp.Response, err) {
-		glog.V(2).Infof("create backing off: creating route: instance=%q cidr=%q", kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
-		retryErr := az.CreateOrUpdateRouteWithRetry(route)
-		if retryErr != nil {
-			err = retryErr
-			glog.V(2).Infof("create abort backoff: creating route: instance=%q cidr=%q", kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
-		}
-	}
-	if err != nil {
+	routeTableName := az.routeTableNameForNode(kubeRoute.TargetNode)
+	if err := az.getRouteUpdater(routeTableName).enqueue(routeOperation{route: route}); err != nil {
 		return err
 	}
 
@@ -143,26 +333,16 @@ func (az *Cloud) CreateRoute(clusterName string, nameHint string, kubeRoute *clo
 
 // DeleteRoute deletes the specified managed route
 // Route should be as returned by ListRoutes
+// DeleteRoute enqueues the removal with the Cloud's routeUpdater rather than issuing its
+// own route-table PUT; see CreateRoute.
 func (az *Cloud) DeleteRoute(clusterName string, kubeRoute *cloudprovider.Route) error {
 	glog.V(2).Infof("delete: deleting route. clusterName=%q instance=%q cidr=%q", clusterName, kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
 
-	routeName := mapNodeNameToRouteName(kubeRoute.TargetNode)
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("RoutesClient.Delete(%q): start", az.RouteTableName)
-	respChan, errChan := az.RoutesClient.Delete(az.ResourceGroup, az.RouteTableName, routeName, nil)
-	resp := <-respChan
-	err := <-errChan
-	glog.V(10).Infof("RoutesClient.Delete(%q): end", az.RouteTableName)
-
-	if az.CloudProviderBackoff && shouldRetryAPIRequest(resp, err) {
-		glog.V(2).Infof("delete backing off: deleting route. clusterName=%q instance=%q cidr=%q", clusterName, kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
-		retryErr := az.DeleteRouteWithRetry(routeName)
-		if retryErr != nil {
-			err = retryErr
-			glog.V(2).Infof("delete abort backoff: deleting route. clusterName=%q instance=%q cidr=%q", clusterName, kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
-		}
-	}
-	if err != nil {
+	routeName := mapNodeNameToRouteName(kubeRoute.TargetNode, kubeRoute.DestinationCIDR)
+	route := network.Route{Name: to.StringPtr(routeName)}
+
+	routeTableName := az.routeTableNameForNode(kubeRoute.TargetNode)
+	if err := az.getRouteUpdater(routeTableName).enqueue(routeOperation{route: route, isDeleted: true}); err != nil {
 		return err
 	}
 
@@ -170,15 +350,23 @@ func (az *Cloud) DeleteRoute(clusterName string, kubeRoute *cloudprovider.Route)
 	return nil
 }
 
+// ipv6RouteNameSuffix is appended to the route name for IPv6 pod CIDRs so that a
+// dual-stack node, which gets one route per address family, doesn't collide the two
+// routes under the same name.
+const ipv6RouteNameSuffix = "-v6"
+
 // This must be kept in sync with mapRouteNameToNodeName.
 // These two functions enable stashing the instance name in the route
 // and then retrieving it later when listing. This is needed because
 // Azure does not let you put tags/descriptions on the Route itself.
-func mapNodeNameToRouteName(nodeName types.NodeName) string {
+func mapNodeNameToRouteName(nodeName types.NodeName, cidr string) string {
+	if strings.Contains(cidr, ":") {
+		return fmt.Sprintf("%s%s", nodeName, ipv6RouteNameSuffix)
+	}
 	return fmt.Sprintf("%s", nodeName)
 }
 
 // Used with mapNodeNameToRouteName. See comment on mapNodeNameToRouteName.
 func mapRouteNameToNodeName(routeName string) types.NodeName {
-	return types.NodeName(fmt.Sprintf("%s", routeName))
+	return types.NodeName(strings.TrimSuffix(routeName, ipv6RouteNameSuffix))
 }
@@ -0,0 +1,147 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func routeWithPrefix(name, prefix string) network.Route {
+	return network.Route{
+		Name: to.StringPtr(name),
+		RoutePropertiesFormat: &network.RoutePropertiesFormat{
+			AddressPrefix:    to.StringPtr(prefix),
+			NextHopIPAddress: to.StringPtr("10.0.0.1"),
+			NextHopType:      network.RouteNextHopTypeVirtualAppliance,
+		},
+	}
+}
+
+func routeNames(routes []network.Route) []string {
+	var names []string
+	for _, r := range routes {
+		names = append(names, *r.Name)
+	}
+	return names
+}
+
+func TestMergeRouteOperationsAddsNewRoute(t *testing.T) {
+	ops := []routeOperation{{route: routeWithPrefix("node-a", "10.244.0.0/24")}}
+
+	routes, changed := mergeRouteOperations(nil, ops)
+	if !changed {
+		t.Fatal("got changed=false, want true")
+	}
+	if got := routeNames(routes); len(got) != 1 || got[0] != "node-a" {
+		t.Errorf("got routes %v, want [node-a]", got)
+	}
+}
+
+func TestMergeRouteOperationsDeletesExistingRoute(t *testing.T) {
+	existing := []network.Route{routeWithPrefix("node-a", "10.244.0.0/24")}
+	ops := []routeOperation{{route: network.Route{Name: to.StringPtr("node-a")}, isDeleted: true}}
+
+	routes, changed := mergeRouteOperations(existing, ops)
+	if !changed {
+		t.Fatal("got changed=false, want true")
+	}
+	if len(routes) != 0 {
+		t.Errorf("got routes %v, want none", routeNames(routes))
+	}
+}
+
+func TestMergeRouteOperationsDeletingMissingRouteIsNoop(t *testing.T) {
+	ops := []routeOperation{{route: network.Route{Name: to.StringPtr("node-a")}, isDeleted: true}}
+
+	routes, changed := mergeRouteOperations(nil, ops)
+	if changed {
+		t.Error("got changed=true, want false (nothing to delete)")
+	}
+	if len(routes) != 0 {
+		t.Errorf("got routes %v, want none", routeNames(routes))
+	}
+}
+
+func TestMergeRouteOperationsSameRouteIsNoop(t *testing.T) {
+	existing := []network.Route{routeWithPrefix("node-a", "10.244.0.0/24")}
+	ops := []routeOperation{{route: routeWithPrefix("node-a", "10.244.0.0/24")}}
+
+	routes, changed := mergeRouteOperations(existing, ops)
+	if changed {
+		t.Error("got changed=true, want false (route is identical)")
+	}
+	if got := routeNames(routes); len(got) != 1 || got[0] != "node-a" {
+		t.Errorf("got routes %v, want [node-a]", got)
+	}
+}
+
+// TestMergeRouteOperationsLaterOpWinsWithinBatch verifies the dedup-by-name-wins rule: when
+// a batch has more than one pending operation for the same route name (e.g. a stale create
+// immediately followed by a delete), only the last one queued takes effect.
+func TestMergeRouteOperationsLaterOpWinsWithinBatch(t *testing.T) {
+	existing := []network.Route{routeWithPrefix("node-a", "10.244.0.0/24")}
+	ops := []routeOperation{
+		{route: routeWithPrefix("node-a", "10.244.0.0/24")},
+		{route: network.Route{Name: to.StringPtr("node-a")}, isDeleted: true},
+	}
+
+	routes, changed := mergeRouteOperations(existing, ops)
+	if !changed {
+		t.Fatal("got changed=false, want true")
+	}
+	if len(routes) != 0 {
+		t.Errorf("got routes %v, want none (delete should have won over the earlier create)", routeNames(routes))
+	}
+}
+
+// TestMergeRouteOperationsLaterOpWinsOverwritingCreate verifies the reverse ordering: a
+// delete followed by a create for the same name should leave the route present with the
+// create's properties, not deleted.
+func TestMergeRouteOperationsLaterOpWinsOverwritingCreate(t *testing.T) {
+	existing := []network.Route{routeWithPrefix("node-a", "10.244.0.0/24")}
+	ops := []routeOperation{
+		{route: network.Route{Name: to.StringPtr("node-a")}, isDeleted: true},
+		{route: routeWithPrefix("node-a", "10.244.1.0/24")},
+	}
+
+	routes, changed := mergeRouteOperations(existing, ops)
+	if !changed {
+		t.Fatal("got changed=false, want true")
+	}
+	if len(routes) != 1 || *routes[0].AddressPrefix != "10.244.1.0/24" {
+		t.Errorf("got routes %v, want a single node-a route with prefix 10.244.1.0/24", routeNames(routes))
+	}
+}
+
+func TestMergeRouteOperationsHandlesMultipleDistinctNodes(t *testing.T) {
+	existing := []network.Route{routeWithPrefix("node-a", "10.244.0.0/24")}
+	ops := []routeOperation{
+		{route: routeWithPrefix("node-b", "10.244.1.0/24")},
+		{route: network.Route{Name: to.StringPtr("node-a")}, isDeleted: true},
+	}
+
+	routes, changed := mergeRouteOperations(existing, ops)
+	if !changed {
+		t.Fatal("got changed=false, want true")
+	}
+	if got := routeNames(routes); len(got) != 1 || got[0] != "node-b" {
+		t.Errorf("got routes %v, want [node-b]", got)
+	}
+}
@@ -19,43 +19,226 @@ package azure
 import (
 	"fmt"
 
+	"github.com/Azure/azure-sdk-for-go/arm/storage"
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/golang/glog"
 )
 
+const (
+	// maxSharesPerStorageAccount caps how many shares this pooling logic will pile onto a
+	// single account before preferring to spread new shares onto another one. It's a
+	// conservative default well under Azure's per-account limits, chosen to keep any one
+	// account's aggregate share IOPS/throughput from becoming a shared bottleneck.
+	maxSharesPerStorageAccount = 20
+	// fileShareAccountUtilizationBeforeGrowing mirrors storageAccountUtilizationBeforeGrowing
+	// from the blob disk controller: once matching accounts are this full on average, prefer
+	// creating a new account over adding more shares to existing ones.
+	fileShareAccountUtilizationBeforeGrowing = 0.5
+	// maxFileShareStorageAccounts bounds how many accounts the pool will create for shares of
+	// a given sku/location before it starts packing existing accounts past the utilization
+	// target instead.
+	maxFileShareStorageAccounts = 100
+)
+
 // CreateFileShare creates a file share, using a matching storage account
-func (az *Cloud) CreateFileShare(name, storageAccount, storageType, location string, requestGB int) (string, string, error) {
-	var err error
-	accounts := []accountWithLocation{}
+func (az *Cloud) CreateFileShare(name, storageAccount, storageType, location string, requestGB int, privateEndpointSubnet string, enableLargeFileShares bool) (string, string, error) {
+	if enableLargeFileShares && storageAccount == "" {
+		// Enabling the largeFileShares feature on an account needs an
+		// AccountPropertiesCreateParameters.LargeFileSharesState field that the vendored storage
+		// SDK doesn't have, so it can't be set when this provider creates an account on demand.
+		// A pooled account could also be selected below without the feature enabled. Require an
+		// explicit, pre-created account known to have the feature on instead of guessing.
+		return "", "", fmt.Errorf("largeFileSharesEnabled requires a pre-created storage account with the " +
+			"largeFileShares feature already enabled, referenced with the storageAccount StorageClass parameter: " +
+			"the vendored Azure storage SDK doesn't support enabling that feature when creating an account")
+	}
+
+	if privateEndpointSubnet != "" {
+		// Disabling public network access on the account and attaching a private endpoint
+		// needs a storage account NetworkRuleSet plus an arm/network PrivateEndpoint resource,
+		// neither of which exist in the storage/network SDKs vendored into this tree. Until
+		// they're updated, private endpoints for file shares must be set up out-of-band against
+		// a pre-created account referenced via the storageAccount parameter.
+		return "", "", fmt.Errorf("cannot create a file share with a private endpoint: the vendored Azure "+
+			"storage and network SDKs don't support storage account network rules or private endpoints; "+
+			"pre-create the account and endpoint and reference the account with the storageAccount "+
+			"StorageClass parameter instead (privateEndpointSubnet=%q)", privateEndpointSubnet)
+	}
+
 	if len(storageAccount) > 0 {
-		accounts = append(accounts, accountWithLocation{Name: storageAccount})
-	} else {
-		// find a storage account
-		accounts, err = az.getStorageAccounts()
+		if err := az.validateStorageAccount(storageAccount, storageType, location); err != nil {
+			return "", "", err
+		}
+		key, err := az.getStorageAccesskey(storageAccount)
 		if err != nil {
-			// TODO: create a storage account and container
+			return "", "", fmt.Errorf("could not get an access key for storage account %q: %v", storageAccount, err)
+		}
+		if err := az.createFileShare(storageAccount, key, name, requestGB); err != nil {
 			return "", "", err
 		}
+		glog.V(4).Infof("created share %s in account %s", name, storageAccount)
+		return storageAccount, key, nil
 	}
+
+	// No account was pinned by the caller, so spread the share across the shared pool of
+	// accounts matching this sku/location instead of always reusing whichever account
+	// happens to be listed first.
+	account, key, err := az.pickFileShareAccount(storageType, location)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := az.createFileShare(account, key, name, requestGB); err != nil {
+		return "", "", fmt.Errorf("failed to create file share %s in account %s, err: %v", name, account, err)
+	}
+	glog.V(4).Infof("created share %s in account %s", name, account)
+	return account, key, nil
+}
+
+// validateStorageAccount confirms that an explicitly requested storage account exists and, if
+// the StorageClass also asked for a particular sku/location, that the account actually matches
+// them, so a mismatched account fails provisioning up front instead of silently creating a
+// share that doesn't meet the requested performance tier or region.
+func (az *Cloud) validateStorageAccount(storageAccount, storageType, location string) error {
+	accounts, err := az.getStorageAccounts()
+	if err != nil {
+		return fmt.Errorf("could not list storage accounts to validate %q: %v", storageAccount, err)
+	}
+
 	for _, account := range accounts {
-		glog.V(4).Infof("account %s type %s location %s", account.Name, account.StorageType, account.Location)
-		if ((storageType == "" || account.StorageType == storageType) && (location == "" || account.Location == location)) || len(storageAccount) > 0 {
-			// find the access key with this account
-			key, err := az.getStorageAccesskey(account.Name)
-			if err != nil {
-				glog.V(2).Infof("no key found for storage account %s", account.Name)
-				continue
-			}
-
-			err = az.createFileShare(account.Name, key, name, requestGB)
-			if err != nil {
-				glog.V(2).Infof("failed to create share %s in account %s: %v", name, account.Name, err)
-				continue
-			}
-			glog.V(4).Infof("created share %s in account %s", name, account.Name)
-			return account.Name, key, err
+		if account.Name != storageAccount {
+			continue
+		}
+		if storageType != "" && account.StorageType != storageType {
+			return fmt.Errorf("storage account %q is of sku %q, not the requested %q", storageAccount, account.StorageType, storageType)
+		}
+		if location != "" && account.Location != location {
+			return fmt.Errorf("storage account %q is in location %q, not the requested %q", storageAccount, account.Location, location)
 		}
+		return nil
+	}
+
+	return fmt.Errorf("could not find a storage account matching %q", storageAccount)
+}
+
+// pickFileShareAccount returns the name and key of the least-utilized existing storage
+// account matching storageType/location, or creates a new one if none match, matching
+// accounts are all missing keys, or the matching accounts are, on average, past
+// fileShareAccountUtilizationBeforeGrowing full.
+func (az *Cloud) pickFileShareAccount(storageType, location string) (string, string, error) {
+	accounts, err := az.getStorageAccounts()
+	if err != nil {
+		return az.createFileShareStorageAccount(storageType, location)
 	}
-	return "", "", fmt.Errorf("failed to find a matching storage account")
+
+	bestAccount, bestKey := "", ""
+	bestShareCount := -1
+	matchingAccounts, totalShares := 0, 0
+	for _, account := range accounts {
+		if (storageType != "" && account.StorageType != storageType) || (location != "" && account.Location != location) {
+			continue
+		}
+		key, err := az.getStorageAccesskey(account.Name)
+		if err != nil {
+			glog.V(2).Infof("azureFile - no key found for storage account %s, skipping", account.Name)
+			continue
+		}
+		shareCount, err := az.getFileShareCount(account.Name, key)
+		if err != nil {
+			glog.V(2).Infof("azureFile - failed to count shares in account %s, skipping: %v", account.Name, err)
+			continue
+		}
+
+		glog.V(4).Infof("azureFile - account %s has %d shares", account.Name, shareCount)
+		matchingAccounts++
+		totalShares += shareCount
+		if bestShareCount == -1 || shareCount < bestShareCount {
+			bestShareCount = shareCount
+			bestAccount, bestKey = account.Name, key
+		}
+	}
+
+	if bestAccount == "" {
+		return az.createFileShareStorageAccount(storageType, location)
+	}
+
+	utilization := float64(totalShares+1) / float64(matchingAccounts*maxSharesPerStorageAccount)
+	if utilization > fileShareAccountUtilizationBeforeGrowing && matchingAccounts < maxFileShareStorageAccounts {
+		glog.V(2).Infof("azureFile - shared storage accounts utilization(%v) > grow-at-avg-utilization(%v), creating a new account", utilization, fileShareAccountUtilizationBeforeGrowing)
+		if name, key, err := az.createFileShareStorageAccount(storageType, location); err == nil {
+			return name, key, nil
+		}
+		// Fall through and keep piling onto the least-utilized existing account rather than
+		// failing provisioning outright if a new account couldn't be created (e.g. quota).
+	}
+
+	return bestAccount, bestKey, nil
+}
+
+// createFileShareStorageAccount provisions a new storage account for the shared file share
+// pool, returning its name and access key.
+func (az *Cloud) createFileShareStorageAccount(storageType, location string) (string, string, error) {
+	sku := storage.SkuName(storageType)
+	if sku == "" {
+		sku = storage.StandardLRS
+	}
+	if sku == storage.PremiumLRS {
+		// Premium file shares require a storage account of kind "FileStorage", which isn't
+		// defined by the storage SDK vendored into this tree (only Storage and BlobStorage
+		// are). Until that SDK is updated, a premium share can only be backed by a
+		// pre-created FileStorage account referenced via the storageAccount parameter.
+		return "", "", fmt.Errorf("cannot create a new %s storage account for a file share: creating a "+
+			"FileStorage-kind account requires a newer Azure storage SDK than the one vendored into this tree; "+
+			"pre-create the account and reference it with the storageAccount StorageClass parameter instead", sku)
+	}
+
+	if location == "" {
+		location = az.Location
+	}
+	accountName := generateStorageAccountName(fileShareAccountNamePrefix)
+
+	glog.V(2).Infof("azureFile - creating storage account %s type %s location %s", accountName, sku, location)
+	cp := storage.AccountCreateParameters{
+		Sku:      &storage.Sku{Name: sku},
+		Tags:     &map[string]*string{"created-by": to.StringPtr("azure-file")},
+		Location: &location,
+	}
+	cancel := make(chan struct{})
+	az.operationPollRateLimiter.Accept()
+	_, errChan := az.StorageAccountClient.Create(az.ResourceGroup, accountName, cp, cancel)
+	if err := <-errChan; err != nil {
+		return "", "", fmt.Errorf("failed to create storage account %s, err: %v", accountName, err)
+	}
+
+	key, err := az.getStorageAccesskey(accountName)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get key for storage account %s, err: %v", accountName, err)
+	}
+	return accountName, key, nil
+}
+
+// ResizeFileShare resizes a file share, using the provided storage account name and key
+func (az *Cloud) ResizeFileShare(accountName, key, name string, sizeGB int) error {
+	if err := az.resizeFileShare(accountName, key, name, sizeGB); err != nil {
+		return err
+	}
+	glog.V(4).Infof("resized share %s to %d GiB", name, sizeGB)
+	return nil
+}
+
+// CreateShareSnapshot creates a point-in-time, read-only snapshot of an existing file share.
+func (az *Cloud) CreateShareSnapshot(accountName, accountKey, name string) (string, error) {
+	return az.createFileShareSnapshot(accountName, accountKey, name)
+}
+
+// DeleteShareSnapshot deletes a previously created file share snapshot.
+func (az *Cloud) DeleteShareSnapshot(accountName, accountKey, name, snapshot string) error {
+	return az.deleteFileShareSnapshot(accountName, accountKey, name, snapshot)
+}
+
+// ListShareSnapshots lists the snapshots that exist for a file share.
+func (az *Cloud) ListShareSnapshots(accountName, accountKey, name string) ([]string, error) {
+	return az.listFileShareSnapshots(accountName, accountKey, name)
 }
 
 // DeleteFileShare deletes a file share using storage account name and key
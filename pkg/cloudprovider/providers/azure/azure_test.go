@@ -35,6 +35,14 @@ import (
 
 var testClusterName = "testCluster"
 
+// testTenantID, testSubscriptionID, and testAADClientID are valid-looking (but not real) UUIDs
+// for the config fixtures below, which validateAzureConfig now requires to be well-formed UUIDs.
+var (
+	testTenantID       = "00000000-0000-0000-0000-000000000001"
+	testSubscriptionID = "00000000-0000-0000-0000-000000000002"
+	testAADClientID    = "00000000-0000-0000-0000-000000000003"
+)
+
 // Test additional of a new service/port.
 func TestReconcileLoadBalancerAddPort(t *testing.T) {
 	az := getTestCloud()
@@ -742,12 +750,10 @@ func TestProtocolTranslationUDP(t *testing.T) {
 // Test Configuration deserialization (json)
 func TestNewCloudFromJSON(t *testing.T) {
 	config := `{
-		"tenantId": "--tenant-id--",
-		"subscriptionId": "--subscription-id--",
-		"aadClientId": "--aad-client-id--",
+		"tenantId": "` + testTenantID + `",
+		"subscriptionId": "` + testSubscriptionID + `",
+		"aadClientId": "` + testAADClientID + `",
 		"aadClientSecret": "--aad-client-secret--",
-		"aadClientCertPath": "--aad-client-cert-path--",
-		"aadClientCertPassword": "--aad-client-cert-password--",
 		"resourceGroup": "--resource-group--",
 		"location": "--location--",
 		"subnetName": "--subnet-name--",
@@ -767,10 +773,39 @@ func TestNewCloudFromJSON(t *testing.T) {
 	validateConfig(t, config)
 }
 
+// Test that AADClientCertPath/AADClientCertPassword deserialize correctly when certificate-based
+// auth, rather than aadClientSecret, is the configured auth method. This only exercises
+// ParseConfig, not the full NewCloud, since actually authenticating with a certificate needs a
+// real PKCS12 file on disk to read - and validateAzureConfig treats AADClientSecret and
+// AADClientCertPath as mutually exclusive, so they can't both be set in the same fixture either.
+func TestNewCloudFromJSONWithClientCertAuth(t *testing.T) {
+	config := `{
+		"tenantId": "` + testTenantID + `",
+		"subscriptionId": "` + testSubscriptionID + `",
+		"aadClientId": "` + testAADClientID + `",
+		"aadClientCertPath": "--aad-client-cert-path--",
+		"aadClientCertPassword": "--aad-client-cert-password--",
+		"resourceGroup": "--resource-group--"
+	}`
+	parsed, _, err := ParseConfig(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if parsed.AADClientCertPath != "--aad-client-cert-path--" {
+		t.Errorf("got incorrect value for AADClientCertPath")
+	}
+	if parsed.AADClientCertPassword != "--aad-client-cert-password--" {
+		t.Errorf("got incorrect value for AADClientCertPassword")
+	}
+}
+
 // Test Backoff and Rate Limit defaults (json)
 func TestCloudDefaultConfigFromJSON(t *testing.T) {
 	config := `{
-                "aadClientId": "--aad-client-id--",
+                "tenantId": "` + testTenantID + `",
+                "subscriptionId": "` + testSubscriptionID + `",
+                "resourceGroup": "--resource-group--",
+                "aadClientId": "` + testAADClientID + `",
                 "aadClientSecret": "--aad-client-secret--"
         }`
 
@@ -780,7 +815,10 @@ func TestCloudDefaultConfigFromJSON(t *testing.T) {
 // Test Backoff and Rate Limit defaults (yaml)
 func TestCloudDefaultConfigFromYAML(t *testing.T) {
 	config := `
-aadClientId: --aad-client-id--
+tenantId: ` + testTenantID + `
+subscriptionId: ` + testSubscriptionID + `
+resourceGroup: --resource-group--
+aadClientId: ` + testAADClientID + `
 aadClientSecret: --aad-client-secret--
 `
 	validateEmptyConfig(t, config)
@@ -789,12 +827,10 @@ aadClientSecret: --aad-client-secret--
 // Test Configuration deserialization (yaml)
 func TestNewCloudFromYAML(t *testing.T) {
 	config := `
-tenantId: --tenant-id--
-subscriptionId: --subscription-id--
-aadClientId: --aad-client-id--
+tenantId: ` + testTenantID + `
+subscriptionId: ` + testSubscriptionID + `
+aadClientId: ` + testAADClientID + `
 aadClientSecret: --aad-client-secret--
-aadClientCertPath: --aad-client-cert-path--
-aadClientCertPassword: --aad-client-cert-password--
 resourceGroup: --resource-group--
 location: --location--
 subnetName: --subnet-name--
@@ -817,24 +853,18 @@ cloudProviderRateLimitBucket: 5
 func validateConfig(t *testing.T, config string) {
 	azureCloud := getCloudFromConfig(t, config)
 
-	if azureCloud.TenantID != "--tenant-id--" {
+	if azureCloud.TenantID != testTenantID {
 		t.Errorf("got incorrect value for TenantID")
 	}
-	if azureCloud.SubscriptionID != "--subscription-id--" {
+	if azureCloud.SubscriptionID != testSubscriptionID {
 		t.Errorf("got incorrect value for SubscriptionID")
 	}
-	if azureCloud.AADClientID != "--aad-client-id--" {
+	if azureCloud.AADClientID != testAADClientID {
 		t.Errorf("got incorrect value for AADClientID")
 	}
 	if azureCloud.AADClientSecret != "--aad-client-secret--" {
 		t.Errorf("got incorrect value for AADClientSecret")
 	}
-	if azureCloud.AADClientCertPath != "--aad-client-cert-path--" {
-		t.Errorf("got incorrect value for AADClientCertPath")
-	}
-	if azureCloud.AADClientCertPassword != "--aad-client-cert-password--" {
-		t.Errorf("got incorrect value for AADClientCertPassword")
-	}
 	if azureCloud.ResourceGroup != "--resource-group--" {
 		t.Errorf("got incorrect value for ResourceGroup")
 	}
@@ -886,11 +916,11 @@ func getCloudFromConfig(t *testing.T, config string) *Cloud {
 	configReader := strings.NewReader(config)
 	cloud, err := NewCloud(configReader)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	azureCloud, ok := cloud.(*Cloud)
 	if !ok {
-		t.Error("NewCloud returned incorrect type")
+		t.Fatal("NewCloud returned incorrect type")
 	}
 	return azureCloud
 }
@@ -0,0 +1,221 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const azureRateLimitSubsystem = "azure_cloudprovider"
+
+const (
+	headerRetryAfter               = "Retry-After"
+	headerRateLimitRemainingReads  = "x-ms-ratelimit-remaining-subscription-reads"
+	headerRateLimitRemainingWrites = "x-ms-ratelimit-remaining-subscription-writes"
+
+	// armErrorCodeSubscriptionThrottled is the ARM error code returned when a subscription (as
+	// opposed to a single resource provider) has exhausted its request quota. It shows up in the
+	// JSON error body of a 429 response, not in a header, and is worth alerting on separately
+	// from a garden-variety per-provider 429 because it means every ARM call this cluster makes
+	// is about to start failing, not just calls to one resource type.
+	armErrorCodeSubscriptionThrottled = "SubscriptionRequestsThrottled"
+
+	// storageErrorCodeServerBusy is the error code the Storage service returns (alongside a 503)
+	// when blob operations are being throttled on IOPS/bandwidth, distinct from ARM throttling.
+	storageErrorCodeServerBusy = "ServerBusy"
+)
+
+var (
+	rateLimitRemainingReads = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: azureRateLimitSubsystem,
+			Name:      "ratelimit_remaining_reads",
+			Help:      "Remaining ARM read operations for this subscription, from the most recent x-ms-ratelimit-remaining-subscription-reads response header",
+		},
+	)
+	rateLimitRemainingWrites = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: azureRateLimitSubsystem,
+			Name:      "ratelimit_remaining_writes",
+			Help:      "Remaining ARM write operations for this subscription, from the most recent x-ms-ratelimit-remaining-subscription-writes response header",
+		},
+	)
+	armThrottled429Total = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: azureRateLimitSubsystem,
+			Name:      "arm_throttled_total",
+			Help:      "Number of ARM responses with status 429, before any retry this provider performs on the caller's behalf",
+		},
+	)
+	armSubscriptionThrottledTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: azureRateLimitSubsystem,
+			Name:      "arm_subscription_throttled_total",
+			Help:      "Number of ARM 429 responses whose error code was SubscriptionRequestsThrottled, meaning the whole subscription is throttled rather than a single resource provider",
+		},
+	)
+	storageThrottledTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: azureRateLimitSubsystem,
+			Name:      "storage_throttled_total",
+			Help:      "Number of Storage blob operations that failed with a ServerBusy error, indicating the storage account's IOPS or bandwidth limit was exceeded",
+		},
+	)
+)
+
+var registerThrottleMetricsOnce sync.Once
+
+func registerThrottleMetrics() {
+	registerThrottleMetricsOnce.Do(func() {
+		prometheus.MustRegister(rateLimitRemainingReads)
+		prometheus.MustRegister(rateLimitRemainingWrites)
+		prometheus.MustRegister(armThrottled429Total)
+		prometheus.MustRegister(armSubscriptionThrottledTotal)
+		prometheus.MustRegister(storageThrottledTotal)
+	})
+}
+
+// throttlingSender watches every ARM response for the x-ms-ratelimit-remaining-subscription-*
+// quota headers and, on a 429, sleeps for the duration ARM asked for in Retry-After and retries
+// once itself, instead of letting the caller's generic resourceRequestBackoff schedule retry
+// blind and dig the throttling hole deeper.
+type throttlingSender struct {
+	next autorest.Sender
+}
+
+func init() {
+	registerThrottleMetrics()
+}
+
+func (s *throttlingSender) Do(req *http.Request) (*http.Response, error) {
+	resp, err := s.next.Do(req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	observeRateLimitHeaders(resp.Header)
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return resp, nil
+	}
+	observeThrottledResponse(resp)
+	delay, ok := parseRetryAfter(resp.Header.Get(headerRetryAfter))
+	if !ok {
+		return resp, nil
+	}
+
+	glog.V(2).Infof("azure: ARM throttled %s %s with 429, honoring Retry-After: %s", req.Method, req.URL.Path, delay)
+	time.Sleep(delay)
+
+	retryReq, err := cloneRequestForRetry(req)
+	if err != nil {
+		glog.V(2).Infof("azure: failed to clone request %s %s for retry after 429: %v", req.Method, req.URL.Path, err)
+		return resp, nil
+	}
+	retryResp, err := s.next.Do(retryReq)
+	if err != nil {
+		return retryResp, err
+	}
+	observeRateLimitHeaders(retryResp.Header)
+	if retryResp.StatusCode == http.StatusTooManyRequests {
+		observeThrottledResponse(retryResp)
+	}
+	return retryResp, nil
+}
+
+// observeThrottledResponse counts a 429 towards the generic throttle counter, then peeks at the
+// ARM error body (without disturbing it for the caller) to see whether this is subscription-wide
+// throttling rather than throttling of the single resource provider being called.
+func observeThrottledResponse(resp *http.Response) {
+	armThrottled429Total.Inc()
+
+	if resp.Body == nil {
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	var armErr struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &armErr); err != nil {
+		return
+	}
+	if armErr.Error.Code == armErrorCodeSubscriptionThrottled {
+		armSubscriptionThrottledTotal.Inc()
+	}
+}
+
+// observeStorageThrottled counts blob-service calls that failed because the storage account's
+// IOPS/bandwidth limit was hit. Unlike ARM throttling this doesn't flow through configureClients'
+// Sender chain, since blob operations go through the separate Storage SDK client used directly by
+// the disk controllers - callers pass the error each such call returns.
+func observeStorageThrottled(err error) {
+	if serr, ok := err.(azstorage.AzureStorageServiceError); ok && serr.Code == storageErrorCodeServerBusy {
+		storageThrottledTotal.Inc()
+	}
+}
+
+func observeRateLimitHeaders(header http.Header) {
+	if v, ok := parseRateLimitHeader(header.Get(headerRateLimitRemainingReads)); ok {
+		rateLimitRemainingReads.Set(v)
+	}
+	if v, ok := parseRateLimitHeader(header.Get(headerRateLimitRemainingWrites)); ok {
+		rateLimitRemainingWrites.Set(v)
+	}
+}
+
+func parseRateLimitHeader(v string) (float64, bool) {
+	if v == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// parseRetryAfter parses ARM's 429 Retry-After header, which is documented to always be a
+// decimal seconds count rather than an HTTP-date.
+// https://docs.microsoft.com/en-us/azure/azure-resource-manager/resource-manager-request-limits
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
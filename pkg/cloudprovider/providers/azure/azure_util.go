@@ -30,6 +30,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/arm/compute"
 	"github.com/Azure/azure-sdk-for-go/arm/network"
 	"github.com/golang/glog"
+	"github.com/satori/uuid"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -38,6 +39,7 @@ const (
 	loadBalancerMaximumPriority = 4096
 
 	machineIDTemplate           = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s"
+	vmssMachineIDTemplate       = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s/virtualMachines/%s"
 	availabilitySetIDTemplate   = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/availabilitySets/%s"
 	frontendIPConfigIDTemplate  = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/loadBalancers/%s/frontendIPConfigurations/%s"
 	backendPoolIDTemplate       = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/loadBalancers/%s/backendAddressPools/%s"
@@ -47,6 +49,7 @@ const (
 )
 
 var providerIDRE = regexp.MustCompile(`^` + CloudProviderName + `://(?:.*)/Microsoft.Compute/virtualMachines/(.+)$`)
+var vmssProviderIDRE = regexp.MustCompile(`^` + CloudProviderName + `://(?:.*)/Microsoft.Compute/virtualMachineScaleSets/(.+)/virtualMachines/(.+)$`)
 
 // returns the full identifier of a machine
 func (az *Cloud) getMachineID(machineName string) string {
@@ -57,6 +60,16 @@ func (az *Cloud) getMachineID(machineName string) string {
 		machineName)
 }
 
+// returns the full identifier of a scale set instance
+func (az *Cloud) getVMSSMachineID(vmssName, instanceID string) string {
+	return fmt.Sprintf(
+		vmssMachineIDTemplate,
+		az.SubscriptionID,
+		az.ResourceGroup,
+		vmssName,
+		instanceID)
+}
+
 // returns the full identifier of an availabilitySet
 func (az *Cloud) getAvailabilitySetID(availabilitySetName string) string {
 	return fmt.Sprintf(
@@ -150,14 +163,30 @@ func getProtocolsFromKubernetesProtocol(protocol v1.Protocol) (*network.Transpor
 
 }
 
-// This returns the full identifier of the primary NIC for the given VM.
-func getPrimaryInterfaceID(machine compute.VirtualMachine) (string, error) {
+// getPrimaryInterfaceID returns the full identifier of the primary NIC for the given VM.
+//
+// If az.PrimaryInterfaceName is set, it takes priority over Azure's own "primary" flag, so
+// that multi-NIC nodes can be pinned to reporting addresses from a specific NIC regardless
+// of which one ARM considers primary.
+func (az *Cloud) getPrimaryInterfaceID(machine compute.VirtualMachine) (string, error) {
 	if len(*machine.NetworkProfile.NetworkInterfaces) == 1 {
 		return *(*machine.NetworkProfile.NetworkInterfaces)[0].ID, nil
 	}
 
+	if az.PrimaryInterfaceName != "" {
+		for _, ref := range *machine.NetworkProfile.NetworkInterfaces {
+			if ref.ID == nil {
+				continue
+			}
+			nicName, err := getLastSegment(*ref.ID)
+			if err == nil && nicName == az.PrimaryInterfaceName {
+				return *ref.ID, nil
+			}
+		}
+	}
+
 	for _, ref := range *machine.NetworkProfile.NetworkInterfaces {
-		if *ref.Primary {
+		if ref.Primary != nil && *ref.Primary {
 			return *ref.ID, nil
 		}
 	}
@@ -271,7 +300,7 @@ func (az *Cloud) getIPForMachine(nodeName types.NodeName) (string, error) {
 		return "", err
 	}
 
-	nicID, err := getPrimaryInterfaceID(machine)
+	nicID, err := az.getPrimaryInterfaceID(machine)
 	if err != nil {
 		glog.Errorf("error: az.getIPForMachine(%s), getPrimaryInterfaceID(%v), err=%v", nodeName, machine, err)
 		return "", err
@@ -283,12 +312,9 @@ func (az *Cloud) getIPForMachine(nodeName types.NodeName) (string, error) {
 		return "", err
 	}
 
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("InterfacesClient.Get(%q): start", nicName)
-	nic, err := az.InterfacesClient.Get(az.ResourceGroup, nicName, "")
-	glog.V(10).Infof("InterfacesClient.Get(%q): end", nicName)
+	nic, err := az.getInterface(nicName)
 	if err != nil {
-		glog.Errorf("error: az.getIPForMachine(%s), az.InterfacesClient.Get(%s, %s, %s), err=%v", nodeName, az.ResourceGroup, nicName, "", err)
+		glog.Errorf("error: az.getIPForMachine(%s), az.getInterface(%s), err=%v", nodeName, nicName, err)
 		return "", err
 	}
 
@@ -302,6 +328,86 @@ func (az *Cloud) getIPForMachine(nodeName types.NodeName) (string, error) {
 	return targetIP, nil
 }
 
+// getNodeAddresses returns the node's hostname plus every private and public IP
+// configured on its primary NIC: the primary IP configuration first (as it always has
+// been), followed by any secondary IP configurations in the order Azure returns them, so
+// that the resulting address list is stable across calls.
+func (az *Cloud) getNodeAddresses(nodeName types.NodeName) ([]v1.NodeAddress, error) {
+	az.operationPollRateLimiter.Accept()
+	machine, exists, err := az.getVirtualMachine(nodeName)
+	if !exists {
+		return nil, cloudprovider.InstanceNotFound
+	}
+	if err != nil {
+		glog.Errorf("error: az.getNodeAddresses(%s), az.getVirtualMachine(%s), err=%v", nodeName, nodeName, err)
+		return nil, err
+	}
+
+	nicID, err := az.getPrimaryInterfaceID(machine)
+	if err != nil {
+		glog.Errorf("error: az.getNodeAddresses(%s), getPrimaryInterfaceID(%v), err=%v", nodeName, machine, err)
+		return nil, err
+	}
+
+	nicName, err := getLastSegment(nicID)
+	if err != nil {
+		glog.Errorf("error: az.getNodeAddresses(%s), getLastSegment(%s), err=%v", nodeName, nicID, err)
+		return nil, err
+	}
+
+	nic, err := az.getInterface(nicName)
+	if err != nil {
+		glog.Errorf("error: az.getNodeAddresses(%s), az.getInterface(%s), err=%v", nodeName, nicName, err)
+		return nil, err
+	}
+
+	var addresses []v1.NodeAddress
+	if nic.IPConfigurations == nil {
+		return []v1.NodeAddress{{Type: v1.NodeHostName, Address: string(nodeName)}}, nil
+	}
+
+	ipConfigs := make([]network.InterfaceIPConfiguration, 0, len(*nic.IPConfigurations))
+	for _, ipConfig := range *nic.IPConfigurations {
+		if ipConfig.Primary != nil && *ipConfig.Primary {
+			ipConfigs = append([]network.InterfaceIPConfiguration{ipConfig}, ipConfigs...)
+		} else {
+			ipConfigs = append(ipConfigs, ipConfig)
+		}
+	}
+
+	for _, ipConfig := range ipConfigs {
+		if ipConfig.PrivateIPAddress != nil {
+			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: *ipConfig.PrivateIPAddress})
+		}
+		if ipConfig.PublicIPAddress == nil || ipConfig.PublicIPAddress.Name == nil {
+			continue
+		}
+
+		az.operationPollRateLimiter.Accept()
+		glog.V(10).Infof("PublicIPAddressesClient.Get(%q): start", *ipConfig.PublicIPAddress.Name)
+		pip, err := az.PublicIPAddressesClient.Get(az.ResourceGroup, *ipConfig.PublicIPAddress.Name, "")
+		glog.V(10).Infof("PublicIPAddressesClient.Get(%q): end", *ipConfig.PublicIPAddress.Name)
+		if err != nil {
+			glog.Errorf("error: az.getNodeAddresses(%s), az.PublicIPAddressesClient.Get(%s), err=%v", nodeName, *ipConfig.PublicIPAddress.Name, err)
+			return nil, err
+		}
+		if pip.PublicIPAddressPropertiesFormat != nil && pip.IPAddress != nil {
+			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: *pip.IPAddress})
+		}
+	}
+
+	// Match the historical ordering of {InternalIP, HostName, ...} so existing callers
+	// that look at addresses[0]/addresses[1] keep working.
+	hostNameAddress := v1.NodeAddress{Type: v1.NodeHostName, Address: string(nodeName)}
+	if len(addresses) == 0 {
+		return []v1.NodeAddress{hostNameAddress}, nil
+	}
+	result := make([]v1.NodeAddress, 0, len(addresses)+1)
+	result = append(result, addresses[0], hostNameAddress)
+	result = append(result, addresses[1:]...)
+	return result, nil
+}
+
 // splitProviderID converts a providerID to a NodeName.
 func splitProviderID(providerID string) (types.NodeName, error) {
 	matches := providerIDRE.FindStringSubmatch(providerID)
@@ -311,6 +417,87 @@ func splitProviderID(providerID string) (types.NodeName, error) {
 	return types.NodeName(matches[1]), nil
 }
 
+// splitVMSSProviderID converts a providerID in the
+// .../virtualMachineScaleSets/<vmss>/virtualMachines/<instanceID> form into its scale set
+// name and instance ID. Unlike splitProviderID, the instance ID it returns is not a node
+// name: scale set instance IDs are opaque integers, not the instance's computer name, so
+// resolving the actual node name additionally requires an API call (see
+// az.nodeNameFromVMSSProviderID).
+func splitVMSSProviderID(providerID string) (vmssName string, instanceID string, err error) {
+	matches := vmssProviderIDRE.FindStringSubmatch(providerID)
+	if len(matches) != 3 {
+		return "", "", errors.New("error splitting VMSS providerID")
+	}
+	return matches[1], matches[2], nil
+}
+
+// nodeNameFromVMSSProviderID resolves a VMSS instance provider ID to the node name (the
+// instance's computer name), which is what the rest of the provider keys node lookups on.
+func (az *Cloud) nodeNameFromVMSSProviderID(providerID string) (types.NodeName, error) {
+	vmssName, instanceID, err := splitVMSSProviderID(providerID)
+	if err != nil {
+		return "", err
+	}
+
+	az.operationPollRateLimiter.Accept()
+	glog.V(10).Infof("VMSSVMClient.Get(%s,%s): start", vmssName, instanceID)
+	vm, err := az.VMSSVMClient.Get(az.ResourceGroup, vmssName, instanceID)
+	glog.V(10).Infof("VMSSVMClient.Get(%s,%s): end", vmssName, instanceID)
+	if err != nil {
+		return "", err
+	}
+	if vm.VirtualMachineScaleSetVMProperties == nil || vm.OsProfile == nil || vm.OsProfile.ComputerName == nil {
+		return "", fmt.Errorf("scale set VM %s/%s has no computer name", vmssName, instanceID)
+	}
+
+	return types.NodeName(*vm.OsProfile.ComputerName), nil
+}
+
+// resolveProviderID converts any providerID this provider hands out - standard VM or VMSS
+// instance - into the corresponding node name.
+//
+// Some callers (older kubelets, other tooling that constructs a providerID by hand) don't
+// preserve the exact "azure://.../Microsoft.Compute/virtualMachines/..." casing this
+// provider itself emits, so both the standard-VM and VMSS forms are additionally retried
+// against a normalized copy of providerID before giving up.
+func (az *Cloud) resolveProviderID(providerID string) (types.NodeName, error) {
+	providerID = strings.TrimSpace(providerID)
+
+	if name, err := splitProviderID(providerID); err == nil {
+		return name, nil
+	}
+	if name, err := splitProviderID(normalizeProviderIDCase(providerID)); err == nil {
+		return name, nil
+	}
+
+	if name, err := az.nodeNameFromVMSSProviderID(providerID); err == nil {
+		return name, nil
+	}
+	return az.nodeNameFromVMSSProviderID(normalizeProviderIDCase(providerID))
+}
+
+// normalizeProviderIDCase rewrites the fixed, non-node-identifying segments of a providerID
+// ("azure://", "/microsoft.compute/virtualmachines/", "/microsoft.compute/virtualmachinescalesets/")
+// to the canonical casing this provider emits, leaving the resource path segments that carry
+// actual identifiers (subscription, resource group, VM/VMSS name) untouched.
+func normalizeProviderIDCase(providerID string) string {
+	lower := strings.ToLower(providerID)
+	replacements := []struct{ canonical, lower string }{
+		{CloudProviderName + "://", strings.ToLower(CloudProviderName) + "://"},
+		{"/Microsoft.Compute/virtualMachineScaleSets/", "/microsoft.compute/virtualmachinescalesets/"},
+		{"/Microsoft.Compute/virtualMachines/", "/microsoft.compute/virtualmachines/"},
+	}
+
+	normalized := providerID
+	for _, r := range replacements {
+		if idx := strings.Index(lower, r.lower); idx >= 0 {
+			normalized = normalized[:idx] + r.canonical + normalized[idx+len(r.lower):]
+			lower = strings.ToLower(normalized)
+		}
+	}
+	return normalized
+}
+
 var polyTable = crc32.MakeTable(crc32.Koopman)
 
 //MakeCRC32 : convert string to CRC32 format
@@ -321,6 +508,22 @@ func MakeCRC32(str string) string {
 	return strconv.FormatUint(uint64(hash), 10)
 }
 
+// fileShareAccountNamePrefix is prepended to storage accounts created on demand for
+// dynamically provisioned Azure file shares.
+const fileShareAccountNamePrefix = "f"
+
+// generateStorageAccountName builds a storage account name that satisfies Azure's naming
+// rules (3-24 lowercase alphanumeric characters, globally unique) from prefix plus a random
+// suffix.
+func generateStorageAccountName(prefix string) string {
+	uniqueID := strings.Replace(uuid.NewV4().String(), "-", "", -1)
+	accountName := strings.ToLower(prefix + uniqueID)
+	if len(accountName) > 24 {
+		accountName = accountName[:24]
+	}
+	return accountName
+}
+
 //ExtractVMData : extract dataDisks, storageProfile from a map struct
 func ExtractVMData(vmData map[string]interface{}) (dataDisks []interface{},
 	storageProfile map[string]interface{},
@@ -347,6 +550,99 @@ func ExtractVMData(vmData map[string]interface{}) (dataDisks []interface{},
 	return dataDisks, storageProfile, hardwareProfile, nil
 }
 
+// parseTags parses a comma-separated key=value tag string (as used by the
+// cluster-wide Config.Tags field and the per-service tag annotation) into a
+// map suitable for assigning to an Azure resource's Tags property.
+func parseTags(tags string) map[string]*string {
+	if tags == "" {
+		return nil
+	}
+
+	m := make(map[string]*string)
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			glog.Warningf("parseTags: skipping malformed tag %q, expected key=value", tag)
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+		m[key] = &value
+	}
+	return m
+}
+
+// tagsEqual reports whether an existing resource's tags already match the
+// desired tag set, so callers can avoid issuing a no-op update.
+func tagsEqual(existing *map[string]*string, desired map[string]*string) bool {
+	if existing == nil {
+		return len(desired) == 0
+	}
+	current := *existing
+	if len(current) != len(desired) {
+		return false
+	}
+	for k, v := range desired {
+		cv, ok := current[k]
+		if !ok || cv == nil || v == nil || *cv != *v {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterNameTagKey tags a provider-created resource (load balancer, public IP, route table,
+// managed disk) with the name of the Kubernetes cluster that owns it, so a subscription hosting
+// more than one cluster - or a human auditing the resource group - can tell which cluster a
+// given resource belongs to.
+const clusterNameTagKey = "kubernetes-cluster-name"
+
+// addClusterNameTag sets clusterNameTagKey to az.ClusterName on tags, allocating tags if it's
+// nil. Returns tags unmodified (including a nil tags) if no ClusterName is configured.
+func (az *Cloud) addClusterNameTag(tags map[string]*string) map[string]*string {
+	if az.ClusterName == "" {
+		return tags
+	}
+	if tags == nil {
+		tags = make(map[string]*string)
+	}
+	clusterName := az.ClusterName
+	tags[clusterNameTagKey] = &clusterName
+	return tags
+}
+
+// mergeTags combines the cluster-wide tags with per-service tags, with the
+// per-service tags taking precedence on key collisions.
+func mergeTags(clusterTags, serviceTags string) map[string]*string {
+	merged := parseTags(clusterTags)
+	for k, v := range parseTags(serviceTags) {
+		if merged == nil {
+			merged = make(map[string]*string)
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// nodeLabelMatches reports whether labels contains the "key=value" pair encoded in kv.
+// It returns false (without warning) for an empty kv, since that means the label-based
+// exclusion feature it backs is simply disabled.
+func nodeLabelMatches(labels map[string]string, kv string) bool {
+	if kv == "" {
+		return false
+	}
+	parts := strings.SplitN(kv, "=", 2)
+	if len(parts) != 2 {
+		glog.Warningf("nodeLabelMatches: %q is not in key=value form", kv)
+		return false
+	}
+	return labels[parts[0]] == parts[1]
+}
+
 //ExtractDiskData : extract provisioningState, diskState from a map struct
 func ExtractDiskData(diskData interface{}) (provisioningState string, diskState string, err error) {
 	fragment, ok := diskData.(map[string]interface{})
@@ -0,0 +1,53 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import "k8s.io/kubernetes/pkg/cloudprovider"
+
+// mixedVMSet implements VMSet for clusters where some node pools are individual VirtualMachines
+// in an availability set and others are Virtual Machine Scale Set instances, selected with
+// VMType: "mixed". Each node name lives in exactly one of the two, so every method tries the
+// availability-set lookup first and falls back to the scale set lookup only on
+// cloudprovider.InstanceNotFound, rather than requiring every node in the cluster to be the same
+// kind up front.
+type mixedVMSet struct {
+	standard VMSet
+	scaleSet VMSet
+}
+
+func newMixedVMSet(az *Cloud) VMSet {
+	return &mixedVMSet{
+		standard: newAvailabilitySet(az),
+		scaleSet: newScaleSet(az),
+	}
+}
+
+func (m *mixedVMSet) GetInstanceIDByNodeName(name string) (string, error) {
+	id, err := m.standard.GetInstanceIDByNodeName(name)
+	if err == cloudprovider.InstanceNotFound {
+		return m.scaleSet.GetInstanceIDByNodeName(name)
+	}
+	return id, err
+}
+
+func (m *mixedVMSet) GetInstanceTypeByNodeName(name string) (string, error) {
+	vmSize, err := m.standard.GetInstanceTypeByNodeName(name)
+	if err == cloudprovider.InstanceNotFound {
+		return m.scaleSet.GetInstanceTypeByNodeName(name)
+	}
+	return vmSize, err
+}
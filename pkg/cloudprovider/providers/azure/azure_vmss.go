@@ -0,0 +1,169 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/arm/compute"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// VMSet abstracts over the two ways nodes can be backed on Azure: individual
+// VirtualMachines placed in an availability set, or instances of a Virtual
+// Machine Scale Set. Cloud methods that need to look up a node's compute
+// instance go through az.vmSet instead of talking to the VirtualMachines API
+// directly, so that VMSS-backed clusters are handled transparently.
+type VMSet interface {
+	// GetInstanceIDByNodeName returns the full Azure resource ID of the
+	// instance backing the given node name.
+	GetInstanceIDByNodeName(name string) (string, error)
+	// GetInstanceTypeByNodeName returns the VM size of the instance backing
+	// the given node name.
+	GetInstanceTypeByNodeName(name string) (string, error)
+}
+
+// availabilitySet implements VMSet for nodes that are individual
+// VirtualMachines, each a member of an availability set. This is the
+// original, and still default, way Kubernetes nodes are deployed on Azure.
+type availabilitySet struct {
+	az *Cloud
+}
+
+func newAvailabilitySet(az *Cloud) VMSet {
+	return &availabilitySet{az: az}
+}
+
+func (as *availabilitySet) GetInstanceIDByNodeName(name string) (string, error) {
+	machine, exists, err := as.az.getVirtualMachine(as.az.mapVMNameToNodeName(name))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", cloudprovider.InstanceNotFound
+	}
+	return *machine.ID, nil
+}
+
+func (as *availabilitySet) GetInstanceTypeByNodeName(name string) (string, error) {
+	machine, exists, err := as.az.getVirtualMachine(as.az.mapVMNameToNodeName(name))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", cloudprovider.InstanceNotFound
+	}
+	return string(machine.HardwareProfile.VMSize), nil
+}
+
+// scaleSet implements VMSet for nodes that are instances of a Virtual
+// Machine Scale Set. Instances are looked up by matching the node name
+// against each instance's OS profile computer name, since the scale set
+// APIs are indexed by instance ID rather than by computer name.
+type scaleSet struct {
+	az *Cloud
+}
+
+func newScaleSet(az *Cloud) VMSet {
+	return &scaleSet{az: az}
+}
+
+// getScaleSetVM finds the scale set VM whose computer name matches nodeName,
+// searching across every scale set in the cluster's resource group. It also
+// returns the parent scale set, since some VM properties (notably the VM
+// size in Uniform orchestration mode) live on the scale set's model rather
+// than on the individual instance.
+func (ss *scaleSet) getScaleSetVM(nodeName string) (compute.VirtualMachineScaleSetVM, compute.VirtualMachineScaleSet, error) {
+	az := ss.az
+	az.operationPollRateLimiter.Accept()
+	glog.V(10).Infof("VMSSClient.List(%s): start", az.ResourceGroup)
+	scaleSets, err := az.VMSSClient.List(az.ResourceGroup)
+	glog.V(10).Infof("VMSSClient.List(%s): end", az.ResourceGroup)
+	if err != nil {
+		return compute.VirtualMachineScaleSetVM{}, compute.VirtualMachineScaleSet{}, err
+	}
+	if scaleSets.Value == nil {
+		return compute.VirtualMachineScaleSetVM{}, compute.VirtualMachineScaleSet{}, cloudprovider.InstanceNotFound
+	}
+
+	for _, vmss := range *scaleSets.Value {
+		az.operationPollRateLimiter.Accept()
+		glog.V(10).Infof("VMSSVMClient.List(%s,%s): start", az.ResourceGroup, *vmss.Name)
+		vms, err := az.VMSSVMClient.List(az.ResourceGroup, *vmss.Name, "", "", "")
+		glog.V(10).Infof("VMSSVMClient.List(%s,%s): end", az.ResourceGroup, *vmss.Name)
+		if err != nil {
+			return compute.VirtualMachineScaleSetVM{}, compute.VirtualMachineScaleSet{}, err
+		}
+		if vms.Value == nil {
+			continue
+		}
+		for _, vm := range *vms.Value {
+			if vm.VirtualMachineScaleSetVMProperties == nil || vm.OsProfile == nil || vm.OsProfile.ComputerName == nil {
+				continue
+			}
+			if *vm.OsProfile.ComputerName == nodeName {
+				return vm, vmss, nil
+			}
+		}
+	}
+
+	return compute.VirtualMachineScaleSetVM{}, compute.VirtualMachineScaleSet{}, cloudprovider.InstanceNotFound
+}
+
+func (ss *scaleSet) GetInstanceIDByNodeName(name string) (string, error) {
+	vm, _, err := ss.getScaleSetVM(name)
+	if err == cloudprovider.InstanceNotFound {
+		// VMSS Flexible orchestration mode nodes are standalone VirtualMachines
+		// associated with a scale set rather than VirtualMachineScaleSetVMs, so
+		// they don't show up under any VMSSVMClient.List result. Fall back to
+		// looking the node up as a plain VM.
+		//
+		// NOTE: the vendored SDK has no field on VirtualMachineProperties for the
+		// scale set association, so we can't distinguish a Flex-mode node from a
+		// standard one up front; this fallback is best-effort.
+		return newAvailabilitySet(ss.az).GetInstanceIDByNodeName(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return *vm.ID, nil
+}
+
+// GetInstanceTypeByNodeName returns the VM size of the scale set instance backing name.
+//
+// In VMSS Uniform orchestration mode, individual instances don't carry their own VM size;
+// it's defined once on the scale set's Sku and every instance conforms to it. Instance
+// HardwareProfile.VMSize is only populated when an instance's model has actually diverged
+// from the scale set's latest model, so that takes priority when present, falling back to
+// the scale set's Sku.Name otherwise.
+func (ss *scaleSet) GetInstanceTypeByNodeName(name string) (string, error) {
+	vm, vmss, err := ss.getScaleSetVM(name)
+	if err == cloudprovider.InstanceNotFound {
+		return newAvailabilitySet(ss.az).GetInstanceTypeByNodeName(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if vm.HardwareProfile != nil && vm.HardwareProfile.VMSize != "" {
+		return string(vm.HardwareProfile.VMSize), nil
+	}
+	if vmss.Sku != nil && vmss.Sku.Name != nil {
+		return *vmss.Sku.Name, nil
+	}
+	return "", fmt.Errorf("scale set VM %q has no VM size information", name)
+}
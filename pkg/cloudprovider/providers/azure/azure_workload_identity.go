@@ -0,0 +1,48 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// federatedTokenSecret implements adal.ServicePrincipalSecret by reading a fresh token off disk
+// on every token acquisition instead of holding a fixed secret or certificate in memory. The file
+// is a projected Kubernetes service account token, OIDC-signed by the cluster's issuer and
+// refreshed by the kubelet well before it expires; exchanging it for an AAD access token requires
+// no VM identity and no SP secret at all, only a federated identity credential configured on the
+// AADClientID application that trusts the cluster's OIDC issuer and this service account.
+type federatedTokenSecret struct {
+	tokenFilePath string
+}
+
+// SetAuthenticationValues is a method of the interface adal.ServicePrincipalSecret. It populates
+// the form submitted during token acquisition with the federated token as a client_assertion, the
+// same JWT-bearer grant shape used for certificate-based auth.
+func (secret *federatedTokenSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	token, err := ioutil.ReadFile(secret.tokenFilePath)
+	if err != nil {
+		return fmt.Errorf("reading the federated token from %s: %v", secret.tokenFilePath, err)
+	}
+	v.Set("client_assertion", string(token))
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	return nil
+}
@@ -29,6 +29,10 @@ import (
 // checkExistsFromError inspects an error and returns a true if err is nil,
 // false if error is an autorest.Error with StatusCode=404 and will return the
 // error back if error is another status code or another type of error.
+//
+// Any other error - a throttled 429, a 5xx, a transport-level timeout - is passed back
+// unchanged with exists=false, so callers must treat a non-nil error as "unknown", not as
+// "doesn't exist"; only a nil error with exists=false means the resource is actually gone.
 func checkResourceExistsFromError(err error) (bool, error) {
 	if err == nil {
 		return true, nil
@@ -37,37 +41,87 @@ func checkResourceExistsFromError(err error) (bool, error) {
 	if ok && v.StatusCode == http.StatusNotFound {
 		return false, nil
 	}
-	return false, v
+	return false, err
 }
 
 func (az *Cloud) getVirtualMachine(nodeName types.NodeName) (vm compute.VirtualMachine, exists bool, err error) {
-	var realErr error
-
-	vmName := string(nodeName)
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("VirtualMachinesClient.Get(%s): start", vmName)
-	vm, err = az.VirtualMachinesClient.Get(az.ResourceGroup, vmName, "")
-	glog.V(10).Infof("VirtualMachinesClient.Get(%s): end", vmName)
-
-	exists, realErr = checkResourceExistsFromError(err)
+	vmName := az.mapNodeNameToVMName(nodeName)
+	cached, err := az.vmCache.get(vmName)
+	exists, realErr := checkResourceExistsFromError(err)
 	if realErr != nil {
 		return vm, false, realErr
 	}
-
 	if !exists {
 		return vm, false, nil
 	}
+	return cached.(compute.VirtualMachine), true, nil
+}
 
-	return vm, exists, err
+// warmVMCache lists every VM in the cluster's resource group in one (paginated) call and seeds
+// az.vmCache with the results, so the attach-detach, route, and service controllers don't each
+// issue their own per-node GET as they discover nodes during startup, right when the API server
+// is also handing them a full list of every Node at once.
+//
+// The List response has no InstanceView, unlike the per-VM Get az.vmCache's getter falls back to
+// on a miss (see azure.go), so a zone lookup against one of these freshly-warmed entries can come
+// up empty until the entry ages out and gets refreshed with InstanceView included; at
+// azureObjectCacheTTL that's a few seconds at most, not worth a second, InstanceView-expanded
+// List call just to close.
+func (az *Cloud) warmVMCache() error {
+	az.operationPollRateLimiter.Accept()
+	glog.V(10).Infof("VirtualMachinesClient.List(%s): start", az.ResourceGroup)
+	result, err := az.VirtualMachinesClient.List(az.ResourceGroup)
+	glog.V(10).Infof("VirtualMachinesClient.List(%s): end", az.ResourceGroup)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for result.Value != nil {
+		for _, vm := range *result.Value {
+			if vm.Name == nil {
+				continue
+			}
+			az.vmCache.set(*vm.Name, vm)
+			count++
+		}
+		if result.NextLink == nil || *result.NextLink == "" {
+			break
+		}
+		az.operationPollRateLimiter.Accept()
+		glog.V(10).Infof("VirtualMachinesClient.ListNextResults(%s): start", az.ResourceGroup)
+		result, err = az.VirtualMachinesClient.ListNextResults(result)
+		glog.V(10).Infof("VirtualMachinesClient.ListNextResults(%s): end", az.ResourceGroup)
+		if err != nil {
+			return err
+		}
+	}
+
+	glog.V(2).Infof("azure: warmed VM cache with %d VMs from resource group %s", count, az.ResourceGroup)
+	return nil
+}
+
+// getInterface fetches a NIC by name, through az.nicCache so the many callers that all want the
+// primary NIC for a VM within the same reconcile don't each issue their own GET.
+func (az *Cloud) getInterface(nicName string) (nic network.Interface, err error) {
+	cached, err := az.nicCache.get(nicName)
+	if err != nil {
+		return nic, err
+	}
+	return cached.(network.Interface), nil
 }
 
 func (az *Cloud) getRouteTable() (routeTable network.RouteTable, exists bool, err error) {
+	return az.getRouteTableByName(az.RouteTableName)
+}
+
+func (az *Cloud) getRouteTableByName(routeTableName string) (routeTable network.RouteTable, exists bool, err error) {
 	var realErr error
 
 	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("RouteTablesClient.Get(%s): start", az.RouteTableName)
-	routeTable, err = az.RouteTablesClient.Get(az.ResourceGroup, az.RouteTableName, "")
-	glog.V(10).Infof("RouteTablesClient.Get(%s): end", az.RouteTableName)
+	glog.V(10).Infof("RouteTablesClient.Get(%s): start", routeTableName)
+	routeTable, err = az.RouteTablesClient.Get(az.ResourceGroup, routeTableName, "")
+	glog.V(10).Infof("RouteTablesClient.Get(%s): end", routeTableName)
 
 	exists, realErr = checkResourceExistsFromError(err)
 	if realErr != nil {
@@ -82,72 +136,56 @@ func (az *Cloud) getRouteTable() (routeTable network.RouteTable, exists bool, er
 }
 
 func (az *Cloud) getSecurityGroup() (sg network.SecurityGroup, exists bool, err error) {
-	var realErr error
-
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("SecurityGroupsClient.Get(%s): start", az.SecurityGroupName)
-	sg, err = az.SecurityGroupsClient.Get(az.ResourceGroup, az.SecurityGroupName, "")
-	glog.V(10).Infof("SecurityGroupsClient.Get(%s): end", az.SecurityGroupName)
-
-	exists, realErr = checkResourceExistsFromError(err)
+	cached, err := az.nsgCache.get(az.SecurityGroupName)
+	exists, realErr := checkResourceExistsFromError(err)
 	if realErr != nil {
 		return sg, false, realErr
 	}
-
 	if !exists {
 		return sg, false, nil
 	}
-
-	return sg, exists, err
+	return cached.(network.SecurityGroup), true, nil
 }
 
 func (az *Cloud) getAzureLoadBalancer(name string) (lb network.LoadBalancer, exists bool, err error) {
-	var realErr error
-
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("LoadBalancerClient.Get(%s): start", name)
-	lb, err = az.LoadBalancerClient.Get(az.ResourceGroup, name, "")
-	glog.V(10).Infof("LoadBalancerClient.Get(%s): end", name)
-
-	exists, realErr = checkResourceExistsFromError(err)
+	cached, err := az.lbCache.get(name)
+	exists, realErr := checkResourceExistsFromError(err)
 	if realErr != nil {
 		return lb, false, realErr
 	}
-
 	if !exists {
 		return lb, false, nil
 	}
-
-	return lb, exists, err
+	return cached.(network.LoadBalancer), true, nil
 }
 
 func (az *Cloud) getPublicIPAddress(name string) (pip network.PublicIPAddress, exists bool, err error) {
-	var realErr error
-
-	az.operationPollRateLimiter.Accept()
-	glog.V(10).Infof("PublicIPAddressesClient.Get(%s): start", name)
-	pip, err = az.PublicIPAddressesClient.Get(az.ResourceGroup, name, "")
-	glog.V(10).Infof("PublicIPAddressesClient.Get(%s): end", name)
-
-	exists, realErr = checkResourceExistsFromError(err)
+	cached, err := az.publicIPCache.get(name)
+	exists, realErr := checkResourceExistsFromError(err)
 	if realErr != nil {
 		return pip, false, realErr
 	}
-
 	if !exists {
 		return pip, false, nil
 	}
-
-	return pip, exists, err
+	return cached.(network.PublicIPAddress), true, nil
 }
 
 func (az *Cloud) getSubnet(virtualNetworkName string, subnetName string) (subnet network.Subnet, exists bool, err error) {
+	rg := az.VnetResourceGroup
+	if rg == "" {
+		rg = az.ResourceGroup
+	}
+	return az.getSubnetInResourceGroup(rg, virtualNetworkName, subnetName)
+}
+
+// getSubnetInResourceGroup looks up a subnet in an explicit resource group, so that
+// internal LB frontends can be placed in a peered or alternate VNet living outside the
+// cluster's own resource group.
+func (az *Cloud) getSubnetInResourceGroup(rg, virtualNetworkName, subnetName string) (subnet network.Subnet, exists bool, err error) {
 	var realErr error
-	var rg string
 
-	if len(az.VnetResourceGroup) > 0 {
-		rg = az.VnetResourceGroup
-	} else {
+	if rg == "" {
 		rg = az.ResourceGroup
 	}
 
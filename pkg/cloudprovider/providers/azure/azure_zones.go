@@ -18,6 +18,7 @@ package azure
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -41,9 +42,24 @@ type instanceInfo struct {
 	FaultDomain  string `json:"FD"`
 }
 
-// GetZone returns the Zone containing the current failure zone and locality region that the program is running in
+// GetZone returns the Zone containing the current failure zone and locality region that the
+// program is running in. The failure zone is discovered locally from the kubelet's own
+// instance metadata service, and the region from that service or, failing that, from the
+// configured Location, rather than from an ARM call, so this works with no AAD credentials
+// configured at all (see hasAzureCredentials).
 func (az *Cloud) GetZone() (cloudprovider.Zone, error) {
+	if az.UseInstanceMetadata {
+		if zone, err := az.metadata.Text("instance/compute/zone"); err == nil && zone != "" {
+			region := az.Location
+			if l, err := az.metadata.Text("instance/compute/location"); err == nil && l != "" {
+				region = l
+			}
+			return cloudprovider.Zone{FailureDomain: zone, Region: region}, nil
+		}
+	}
+
 	faultMutex.Lock()
+	defer faultMutex.Unlock()
 	if faultDomain == nil {
 		var err error
 		faultDomain, err = fetchFaultDomain()
@@ -55,7 +71,6 @@ func (az *Cloud) GetZone() (cloudprovider.Zone, error) {
 		FailureDomain: *faultDomain,
 		Region:        az.Location,
 	}
-	faultMutex.Unlock()
 	return zone, nil
 }
 
@@ -63,7 +78,7 @@ func (az *Cloud) GetZone() (cloudprovider.Zone, error) {
 // This is particularly useful in external cloud providers where the kubelet
 // does not initialize node data.
 func (az *Cloud) GetZoneByProviderID(providerID string) (cloudprovider.Zone, error) {
-	nodeName, err := splitProviderID(providerID)
+	nodeName, err := az.resolveProviderID(providerID)
 	if err != nil {
 		return cloudprovider.Zone{}, err
 	}
@@ -73,15 +88,24 @@ func (az *Cloud) GetZoneByProviderID(providerID string) (cloudprovider.Zone, err
 // GetZoneByNodeName implements Zones.GetZoneByNodeName
 // This is particularly useful in external cloud providers where the kubelet
 // does not initialize node data.
+//
+// Goes through az.getVirtualMachine (and so az.vmCache) rather than its own
+// VirtualMachinesClient.Get, the same VM lookup InstanceID/InstanceType/NodeAddresses already
+// share, instead of the node lifecycle controller's per-node zone check triggering yet another
+// independent ARM call.
 func (az *Cloud) GetZoneByNodeName(nodeName types.NodeName) (cloudprovider.Zone, error) {
-
-	vm, err := az.VirtualMachinesClient.Get(az.ResourceGroup, string(nodeName), compute.InstanceView)
-
+	vm, exists, err := az.getVirtualMachine(nodeName)
 	if err != nil {
 		return cloudprovider.Zone{}, err
 	}
+	if !exists {
+		return cloudprovider.Zone{}, cloudprovider.InstanceNotFound
+	}
 
-	failureDomain := strconv.Itoa(int(*vm.VirtualMachineProperties.InstanceView.PlatformFaultDomain))
+	failureDomain, err := availabilityZone(vm)
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
 
 	zone := cloudprovider.Zone{
 		FailureDomain: failureDomain,
@@ -90,6 +114,59 @@ func (az *Cloud) GetZoneByNodeName(nodeName types.NodeName) (cloudprovider.Zone,
 	return zone, nil
 }
 
+// GetPlatformUpdateDomain returns the platform update domain of the given node, the
+// counterpart to the fault domain reported via GetZoneByNodeName.
+//
+// NOTE: the cloudprovider.Instances/Zones interfaces vendored into this tree have no hook
+// for arbitrary extra node labels, so unlike FailureDomain (applied by the node controller
+// from Zone.FailureDomain) there's nowhere in-tree to plumb this value into a node label
+// yet. It's exposed here as a plain Cloud method for a future caller to use directly.
+func (az *Cloud) GetPlatformUpdateDomain(nodeName types.NodeName) (string, error) {
+	vm, err := az.VirtualMachinesClient.Get(az.ResourceGroup, az.mapNodeNameToVMName(nodeName), compute.InstanceView)
+	if err != nil {
+		return "", err
+	}
+	if vm.VirtualMachineProperties == nil || vm.InstanceView == nil || vm.InstanceView.PlatformUpdateDomain == nil {
+		return "", fmt.Errorf("no update domain information available for VM %q", nodeName)
+	}
+	return strconv.Itoa(int(*vm.InstanceView.PlatformUpdateDomain)), nil
+}
+
+// availabilityZone returns the VM's availability zone, falling back to its platform fault
+// domain when zone information isn't available.
+//
+// NOTE: the vendored Azure Compute SDK's VirtualMachine type has no Zones field, so there's
+// currently no way to report a real Availability Zone here; every VM falls back to its
+// fault domain until the SDK gains that field.
+func availabilityZone(vm compute.VirtualMachine) (string, error) {
+	if vm.VirtualMachineProperties == nil || vm.InstanceView == nil || vm.InstanceView.PlatformFaultDomain == nil {
+		return "", fmt.Errorf("no fault domain information available for VM %q", *vm.Name)
+	}
+	return strconv.Itoa(int(*vm.InstanceView.PlatformFaultDomain)), nil
+}
+
+// GetProximityPlacementGroup returns the resource ID of the proximity placement group the
+// given node's VM is pinned to, for a caller to apply as a node label so pod affinity rules
+// can colocate latency-sensitive workloads.
+//
+// NOTE: the vendored Azure Compute SDK's VirtualMachineProperties has no
+// ProximityPlacementGroup field (it predates that API), so this always returns an error;
+// it's kept as a named, documented stub rather than omitted so the gap is visible and the
+// method signature is ready for the day the vendored SDK is updated.
+func (az *Cloud) GetProximityPlacementGroup(nodeName types.NodeName) (string, error) {
+	return "", fmt.Errorf("azure: proximity placement group lookup requires a newer Azure Compute SDK than the one vendored into this tree")
+}
+
+// GetDedicatedHostGroup returns the resource ID of the dedicated host group the given
+// node's VM is placed on, for a caller to apply as a node label.
+//
+// NOTE: like GetProximityPlacementGroup, this requires a Host/HostGroup property on
+// VirtualMachineProperties that the vendored Azure Compute SDK doesn't have; it always
+// returns an error until the SDK is updated.
+func (az *Cloud) GetDedicatedHostGroup(nodeName types.NodeName) (string, error) {
+	return "", fmt.Errorf("azure: dedicated host group lookup requires a newer Azure Compute SDK than the one vendored into this tree")
+}
+
 func fetchFaultDomain() (*string, error) {
 	resp, err := http.Get(instanceInfoURL)
 	if err != nil {
@@ -73,6 +73,15 @@ func (a *azureDiskAttacher) Attach(spec *volume.Spec, nodeName types.NodeName) (
 		instanceid = instanceid[(ind + 1):]
 	}
 
+	if requiresEncryptionAtHost(spec) {
+		// The vendored compute SDK's VirtualMachineProperties has no SecurityProfile/
+		// EncryptionAtHost field to set (or check) at all - this Azure API generation predates
+		// encryption-at-host - so there is no way for this provider to actually honor a PV
+		// flagged as requiring it. Fail loudly here rather than silently attaching a "sensitive"
+		// disk to a VM this code cannot confirm has encryption-at-host enabled.
+		return "", fmt.Errorf("azureDisk - volume %q requires encryption-at-host, but this provider's vendored compute SDK does not support the VM SecurityProfile.EncryptionAtHost field needed to require or verify it", volumeSource.DiskName)
+	}
+
 	diskController, err := getDiskController(a.plugin.host)
 	if err != nil {
 		return "", err
@@ -183,7 +192,7 @@ func (a *azureDiskAttacher) WaitForAttach(spec *volume.Spec, devicePath string,
 			// the curent sequence k8s uses for unformated disk (check-disk, mount, fail, mkfs.extX) hangs on
 			// Azure Managed disk scsi interface. this is a hack and will be replaced once we identify and solve
 			// the root case on Azure.
-			formatIfNotFormatted(newDevicePath, *volumeSource.FSType, exec)
+			formatIfNotFormatted(newDevicePath, *volumeSource.FSType, getExtraMkfsOptions(spec), getForceFormat(spec), exec)
 			return true, nil
 		}
 
@@ -241,6 +250,9 @@ func (attacher *azureDiskAttacher) MountDevice(spec *volume.Spec, devicePath str
 	if notMnt {
 		diskMounter := volumehelper.NewSafeFormatAndMountFromHost(azureDataDiskPluginName, attacher.plugin.host)
 		mountOptions := volume.MountOptionFromSpec(spec, options...)
+		for _, warning := range validateMountOptions(mountOptions, *volumeSource.CachingMode) {
+			glog.Warningf("azureDisk - %s", warning)
+		}
 		err = diskMounter.FormatAndMount(devicePath, deviceMountPath, *volumeSource.FSType, mountOptions)
 		if err != nil {
 			if cleanErr := os.Remove(deviceMountPath); cleanErr != nil {
@@ -37,8 +37,43 @@ import (
 const (
 	defaultFSType             = "ext4"
 	defaultStorageAccountType = storage.StandardLRS
+
+	// azureDiskMkfsOptionsAnnotation records extra arguments to pass to mkfs when a dynamically
+	// provisioned disk is formatted for the first time, since AzureDiskVolumeSource has no field
+	// for it. Space-separated, e.g. "-b size=8192" for xfs. Absent or empty means no extra args.
+	azureDiskMkfsOptionsAnnotation = "volume.beta.kubernetes.io/azure-disk-mkfs-options"
+
+	// azureDiskKindAnnotation, when set on the PVC being dynamically provisioned, overrides the
+	// StorageClass's "kind" parameter for that PVC alone, so a single class can serve pooled VHD
+	// disks by default while letting individual claims opt into a stand-alone or managed disk.
+	// Validated the same way the StorageClass parameter is, via normalizeKind.
+	azureDiskKindAnnotation = "volume.beta.kubernetes.io/azure-disk-kind"
+
+	// azureDiskFSGroupChangePolicyAnnotation overrides how the mounter applies a pod's fsGroup to
+	// this disk's files. Empty (the default) walks the whole tree and chowns/chmods every file, as
+	// always; azureDiskFSGroupChangePolicySkip skips it entirely; azureDiskFSGroupChangePolicyTop
+	// only touches the mount point's top-level directory. Both non-default settings exist to avoid
+	// the multi-minute pod startup delay a full recursive chown causes on a multi-terabyte disk.
+	azureDiskFSGroupChangePolicyAnnotation = "volume.beta.kubernetes.io/azure-disk-fsgroup-change-policy"
+	azureDiskFSGroupChangePolicySkip       = "Skip"
+	azureDiskFSGroupChangePolicyTop        = "Top"
+
+	// azureDiskRequireEncryptionAtHostAnnotation flags a PV as sensitive enough that it must only
+	// ever be attached to a VM with encryption-at-host enabled. There is no field for this on
+	// AzureDiskVolumeSource, so it's an annotation like the others in this block.
+	azureDiskRequireEncryptionAtHostAnnotation = "volume.beta.kubernetes.io/azure-disk-require-encryption-at-host"
+
+	// azureDiskForceFormatAnnotation opts a statically created PV into formatting even when the
+	// disk already carries a filesystem or partition-table signature. Without it,
+	// formatIfNotFormatted refuses to touch such a disk, since the most common way a disk ends up
+	// with one is a static PV pointing at a disk that was already in use - formatting it anyway
+	// would silently destroy whatever was on it.
+	azureDiskForceFormatAnnotation = "volume.beta.kubernetes.io/azure-disk-force-format"
 )
 
+// dataDisk embeds a volume.MetricsProvider so azureDiskMounter/azureDiskUnmounter/azureDiskDeleter
+// all report capacity and inode usage (kubelet volume stats, "kubectl describe", etc.) for free
+// through makeDataDisk below, the same way aws_ebs and gce_pd wire up their own disk-backed types.
 type dataDisk struct {
 	volume.MetricsProvider
 	volumeName string
@@ -58,6 +93,19 @@ var (
 		string(api.AzureManagedDisk))
 
 	supportedStorageAccountTypes = sets.NewString("Premium_LRS", "Standard_LRS")
+
+	supportedFsTypes = sets.NewString("ext3", "ext4", "xfs", "ntfs")
+
+	supportedFSGroupChangePolicies = sets.NewString(azureDiskFSGroupChangePolicySkip, azureDiskFSGroupChangePolicyTop)
+
+	// mountProfiles maps the "mountprofile" StorageClass parameter to the mount options it expands
+	// to, so a class can hand out tuned settings (e.g. for a throughput-sensitive workload) without
+	// every PV author hand-writing the equivalent StorageClass.mountOptions themselves.
+	// nobarrier is only really safe with cachingMode None - validateMountOptions still warns about
+	// it for other caching modes the same as it would for a hand-written mountOptions entry.
+	mountProfiles = map[string][]string{
+		"throughput": {"noatime", "nobarrier", "discard"},
+	}
 )
 
 func getPath(uid types.UID, volName string, host volume.VolumeHost) string {
@@ -80,6 +128,10 @@ func makeGlobalPDPath(host volume.VolumeHost, diskUri string, isManaged bool) (s
 	return pdPath, nil
 }
 
+// makeDataDisk wires a statfs-based MetricsProvider rooted at the pod-local mount path whenever a
+// pod is known (podUID != ""), i.e. for mounters and unmounters. Deleters call this with an empty
+// podUID and get a nil MetricsProvider back - same as gce_pd's deleter - since a disk being deleted
+// has no pod-local path left to statfs and nothing in this codebase queries a Deleter for metrics.
 func makeDataDisk(volumeName string, podUID types.UID, diskName string, host volume.VolumeHost) *dataDisk {
 	var metricProvider volume.MetricsProvider
 	if podUID != "" {
@@ -106,12 +158,19 @@ func getVolumeSource(spec *volume.Spec) (*v1.AzureDiskVolumeSource, error) {
 	return nil, fmt.Errorf("azureDisk - Spec does not reference an Azure disk volume type")
 }
 
-func normalizeFsType(fsType string) string {
+// normalizeFsType validates fsType against supportedFsTypes, the same way the normalize* helpers
+// below validate their own StorageClass parameter. ntfs only makes sense on Windows nodes, but
+// the check lives here rather than being split by OS.
+func normalizeFsType(fsType string) (string, error) {
 	if fsType == "" {
-		return defaultFSType
+		return defaultFSType, nil
+	}
+
+	if !supportedFsTypes.Has(fsType) {
+		return "", fmt.Errorf("azureDisk - %s is not supported filesystem type. Supported values are %s", fsType, supportedFsTypes.List())
 	}
 
-	return fsType
+	return fsType, nil
 }
 
 func normalizeKind(kind string) (v1.AzureDataDiskKind, error) {
@@ -138,6 +197,89 @@ func normalizeStorageAccountType(storageAccountType string) (storage.SkuName, er
 	return storage.SkuName(storageAccountType), nil
 }
 
+// getExtraMkfsOptions returns the extra mkfs arguments recorded by the provisioner in
+// azureDiskMkfsOptionsAnnotation, or none for a statically created PV (or an inline volume, which
+// has no annotations at all).
+func getExtraMkfsOptions(spec *volume.Spec) []string {
+	if spec.PersistentVolume == nil {
+		return nil
+	}
+	options := spec.PersistentVolume.Annotations[azureDiskMkfsOptionsAnnotation]
+	if options == "" {
+		return nil
+	}
+	return libstrings.Fields(options)
+}
+
+// getFSGroupChangePolicy returns spec's PV's azureDiskFSGroupChangePolicyAnnotation value, or ""
+// (meaning: fall back to the default full recursive chown) for a statically created PV without the
+// annotation, or for an inline volume, which has no annotations at all.
+func getFSGroupChangePolicy(spec *volume.Spec) (string, error) {
+	if spec.PersistentVolume == nil {
+		return "", nil
+	}
+	policy := spec.PersistentVolume.Annotations[azureDiskFSGroupChangePolicyAnnotation]
+	if policy == "" || supportedFSGroupChangePolicies.Has(policy) {
+		return policy, nil
+	}
+	return "", fmt.Errorf("azureDisk - %s is not a supported fsGroup change policy. Supported values are %s", policy, supportedFSGroupChangePolicies.List())
+}
+
+// requiresEncryptionAtHost reports whether spec's PV is flagged as sensitive via
+// azureDiskRequireEncryptionAtHostAnnotation, and so must only be attached to a VM with
+// encryption-at-host enabled.
+func requiresEncryptionAtHost(spec *volume.Spec) bool {
+	if spec.PersistentVolume == nil {
+		return false
+	}
+	return spec.PersistentVolume.Annotations[azureDiskRequireEncryptionAtHostAnnotation] == "true"
+}
+
+// getForceFormat reports whether spec's PV opted into formatting a disk even if it already carries
+// a filesystem or partition-table signature, via azureDiskForceFormatAnnotation. Defaults to false
+// for statically created PVs without the annotation, and for inline volumes (which have none).
+func getForceFormat(spec *volume.Spec) bool {
+	if spec.PersistentVolume == nil {
+		return false
+	}
+	return spec.PersistentVolume.Annotations[azureDiskForceFormatAnnotation] == "true"
+}
+
+// validateMountOptions looks for mount option combinations known to be unsafe on Azure disks and
+// returns a warning message for each one found. It can't reject the mount outright - by the time
+// MountDevice runs, the disk is already attached and the pod is already scheduled onto it - and
+// there's no event recorder reachable from this layer to put the warning somewhere a user would
+// see it (VolumeHost only wires one through for Recycle); logging locally is the best this can do.
+func validateMountOptions(mountOptions []string, cachingMode v1.AzureDataDiskCachingMode) []string {
+	var warnings []string
+	for _, opt := range mountOptions {
+		if opt == "nobarrier" && cachingMode != v1.AzureDataDiskCachingNone {
+			warnings = append(warnings, fmt.Sprintf(
+				"mount option %q disables filesystem write barriers, which risks data loss on an unexpected host reboot while cachingMode is %q instead of %q",
+				opt, cachingMode, v1.AzureDataDiskCachingNone))
+		}
+	}
+	return warnings
+}
+
+// normalizeMountProfile resolves the "mountprofile" StorageClass parameter to the mount options it
+// expands to. An empty profile resolves to no extra mount options.
+func normalizeMountProfile(profile string) ([]string, error) {
+	if profile == "" {
+		return nil, nil
+	}
+
+	options, ok := mountProfiles[profile]
+	if !ok {
+		supported := make([]string, 0, len(mountProfiles))
+		for name := range mountProfiles {
+			supported = append(supported, name)
+		}
+		return nil, fmt.Errorf("azureDisk - %s is not a supported mount profile. Supported values are %s", profile, supported)
+	}
+	return options, nil
+}
+
 func normalizeCachingMode(cachingMode v1.AzureDataDiskCachingMode) (v1.AzureDataDiskCachingMode, error) {
 	if cachingMode == "" {
 		return v1.AzureDataDiskCachingReadWrite, nil
@@ -184,6 +326,9 @@ func getDiskController(host volume.VolumeHost) (DiskController, error) {
 	if !ok || az == nil {
 		return nil, fmt.Errorf("AzureDisk -  failed to get Azure Cloud Provider. GetCloudProvider returned %v instead", cloudProvider)
 	}
+	if !az.DiskControllerEnabled() {
+		return nil, fmt.Errorf("AzureDisk - the disk controller is disabled via disableDiskController in the cloud config")
+	}
 	return az, nil
 }
 
@@ -19,6 +19,7 @@ limitations under the License.
 package azure_dd
 
 import (
+	"fmt"
 	"path"
 	"strconv"
 	libstrings "strings"
@@ -61,10 +62,30 @@ func scsiHostRescan(io ioHandler, exec mount.Exec) {
 }
 
 func findDiskByLun(lun int, io ioHandler, exec mount.Exec) (string, error) {
+	// Prefer the udev-managed /dev/disk/azure/scsi1/lunN symlink: it names the LUN directly,
+	// unlike the sysfs scan below, which has to walk every SCSI device on the bus and infer LUNs
+	// from directory names. On nodes with many transient devices (frequent attach/detach across
+	// pods) that walk is more likely to mis-detect than a symlink udev keeps current. Fall back to
+	// the sysfs scan if the symlink isn't there yet, e.g. on older images without the udev rule.
+	if dev, err := findDiskByLunSymlink(lun, io); err == nil {
+		return dev, nil
+	}
+
 	azureDisks := listAzureDiskPath(io)
 	return findDiskByLunWithConstraint(lun, io, azureDisks)
 }
 
+// findDiskByLunSymlink resolves /dev/disk/azure/scsi1/lunN, the per-LUN symlink Azure's udev
+// rules maintain for attached data disks, to its underlying /dev/sdX device node.
+func findDiskByLunSymlink(lun int, io ioHandler) (string, error) {
+	lunPath := fmt.Sprintf("/dev/disk/azure/scsi1/lun%d", lun)
+	link, err := io.Readlink(lunPath)
+	if err != nil {
+		return "", err
+	}
+	return "/dev/" + link[(libstrings.LastIndex(link, "/")+1):], nil
+}
+
 // finds a device mounted to "current" node
 func findDiskByLunWithConstraint(lun int, io ioHandler, azureDisks []string) (string, error) {
 	var err error
@@ -133,18 +154,29 @@ func findDiskByLunWithConstraint(lun int, io ioHandler, azureDisks []string) (st
 	return "", err
 }
 
-func formatIfNotFormatted(disk string, fstype string, exec mount.Exec) {
+func formatIfNotFormatted(disk string, fstype string, mkfsOptions []string, forceFormat bool, exec mount.Exec) {
 	notFormatted, err := diskLooksUnformatted(disk, exec)
+	if err == nil && !notFormatted && forceFormat {
+		glog.Warningf("azureDisk - Disk %q already carries a filesystem or partition-table signature, but %s is set - formatting anyway", disk, azureDiskForceFormatAnnotation)
+		notFormatted = true
+	}
 	if err == nil && notFormatted {
-		args := []string{disk}
 		// Disk is unformatted so format it.
 		// Use 'ext4' as the default
 		if len(fstype) == 0 {
 			fstype = "ext4"
 		}
-		if fstype == "ext4" || fstype == "ext3" {
-			args = []string{"-E", "lazy_itable_init=0,lazy_journal_init=0", "-F", disk}
+		var flags []string
+		switch fstype {
+		case "ext4", "ext3":
+			flags = []string{"-E", "lazy_itable_init=0,lazy_journal_init=0", "-F"}
+		case "xfs":
+			// -K skips discarding blocks, which can otherwise make first-format of a large,
+			// never-written managed disk take a long time on some backing storage.
+			flags = []string{"-K"}
 		}
+		args := append(flags, mkfsOptions...)
+		args = append(args, disk)
 		glog.Infof("azureDisk - Disk %q appears to be unformatted, attempting to format as type: %q with options: %v", disk, fstype, args)
 
 		_, err := exec.Run("mkfs."+fstype, args...)
@@ -162,8 +194,12 @@ func formatIfNotFormatted(disk string, fstype string, exec mount.Exec) {
 	}
 }
 
+// diskLooksUnformatted reports whether disk has neither a filesystem nor a partition-table
+// signature. Checking FSTYPE alone misses a disk that carries a partition table (MBR/GPT) but no
+// filesystem directly on the raw device - mkfs would happily overwrite that partition table,
+// destroying whatever the partitions held, so PTTYPE is checked right alongside it.
 func diskLooksUnformatted(disk string, exec mount.Exec) (bool, error) {
-	args := []string{"-nd", "-o", "FSTYPE", disk}
+	args := []string{"-nd", "-o", "FSTYPE,PTTYPE", disk}
 	glog.V(4).Infof("Attempting to determine if disk %q is formatted using lsblk with args: (%v)", disk, args)
 	dataOut, err := exec.Run("lsblk", args...)
 	if err != nil {
@@ -105,6 +105,11 @@ func (handler *fakeIOHandler) WriteFile(filename string, data []byte, perm os.Fi
 }
 
 func (handler *fakeIOHandler) Readlink(name string) (string, error) {
+	if strings.HasPrefix(name, "/dev/disk/azure/scsi1/lun") {
+		// simulate an image without the udev rule, forcing the sysfs fallback the rest of this
+		// fake exercises
+		return "", fmt.Errorf("no such file or directory")
+	}
 	return "/dev/azure/disk/sda", nil
 }
 
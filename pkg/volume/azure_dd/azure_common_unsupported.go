@@ -27,5 +27,5 @@ func findDiskByLun(lun int, io ioHandler, exec mount.Exec) (string, error) {
 	return "", nil
 }
 
-func formatIfNotFormatted(disk string, fstype string, exec mount.Exec) {
+func formatIfNotFormatted(disk string, fstype string, mkfsOptions []string, forceFormat bool, exec mount.Exec) {
 }
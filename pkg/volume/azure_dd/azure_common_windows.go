@@ -97,7 +97,13 @@ func findDiskByLun(lun int, iohandler ioHandler, exec mount.Exec) (string, error
 	return "", nil
 }
 
-func formatIfNotFormatted(disk string, fstype string, exec mount.Exec) {
+// formatIfNotFormatted formats disk (found by findDiskByLun above) as NTFS via Initialize-Disk /
+// Format-Volume, mirroring the Linux mkfs path above it. mkfsOptions and forceFormat are accepted
+// for signature parity with the Linux/unsupported builds - the same call site in attacher.go builds
+// them without an OS switch - but neither has a Format-Volume equivalent worth wiring up: Windows
+// nodes only ever format a disk once, on its first attach, since the "Where partitionstyle -eq
+// 'raw'" filter already skips any disk that already has one.
+func formatIfNotFormatted(disk string, fstype string, mkfsOptions []string, forceFormat bool, exec mount.Exec) {
 	if err := mount.ValidateDiskNumber(disk); err != nil {
 		glog.Errorf("azureDisk Mount: formatIfNotFormatted failed, err: %v\n", err)
 		return
@@ -17,10 +17,13 @@ limitations under the License.
 package azure_dd
 
 import (
+	"fmt"
+
 	"github.com/Azure/azure-sdk-for-go/arm/compute"
 	storage "github.com/Azure/azure-sdk-for-go/arm/storage"
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/kubernetes/pkg/util/mount"
 	"k8s.io/kubernetes/pkg/volume"
@@ -51,17 +54,31 @@ type DiskController interface {
 	CreateVolume(name, storageAccount string, storageAccountType storage.SkuName, location string, requestGB int) (string, string, int, error)
 	// Delete a VHD blob
 	DeleteVolume(diskURI string) error
+
+	// Resize a managed disk to newSizeGB, returning the size in GiB ARM actually settled on
+	// (which may be rounded up from what was requested). isManagedDisk mirrors AttachDisk's
+	// parameter of the same name: unmanaged (VHD-backed) disks aren't resizable through this
+	// call and return an error.
+	ResizeDisk(isManagedDisk bool, diskURI string, newSizeGB int) (int, error)
 }
 
 type azureDataDiskPlugin struct {
 	host volume.VolumeHost
 }
 
+// NOTE on raw block volume support: this plugin only ever mounts the attached disk through a
+// filesystem (see azureDiskMounter/Unmounter below). Implementing BlockVolumeMapper/Unmapper to
+// expose the LUN device to pods directly isn't possible in this tree yet - volume.go in this
+// snapshot has no BlockVolumePlugin interface, and v1.PersistentVolumeSpec has no VolumeMode
+// field for a PVC to even request volumeMode: Block. Both are core apimachinery/API changes far
+// outside this plugin's scope; this plugin can pick up block mode once they land upstream.
+
 var _ volume.VolumePlugin = &azureDataDiskPlugin{}
 var _ volume.PersistentVolumePlugin = &azureDataDiskPlugin{}
 var _ volume.DeletableVolumePlugin = &azureDataDiskPlugin{}
 var _ volume.ProvisionableVolumePlugin = &azureDataDiskPlugin{}
 var _ volume.AttachableVolumePlugin = &azureDataDiskPlugin{}
+var _ volume.ExpandableVolumePlugin = &azureDataDiskPlugin{}
 
 const (
 	azureDataDiskPluginName = "kubernetes.io/azure-disk"
@@ -213,3 +230,39 @@ func (plugin *azureDataDiskPlugin) GetDeviceMountRefs(deviceMountPath string) ([
 	m := plugin.host.GetMounter(plugin.GetPluginName())
 	return mount.GetMountRefs(m, deviceMountPath)
 }
+
+// RequiresFSResize reports that growing an azure disk PV isn't enough by itself: the filesystem
+// on the disk still needs to be grown to see the extra space.
+//
+// NOTE: this tree has no node-side resize step to actually do that yet - nothing in the kubelet
+// volume manager here calls a plugin back to resize a filesystem once RequiresFSResize is true,
+// and there's no ResizeFS-style helper in pkg/util/mount to call even if something did (grep
+// finds none; no in-tree plugin implements one). So today ExpandVolumeDevice below grows the
+// cloud disk, but the filesystem on it won't grow until that plumbing exists upstream.
+func (plugin *azureDataDiskPlugin) RequiresFSResize() bool {
+	return true
+}
+
+// ExpandVolumeDevice resizes the cloud-side disk backing spec to newSize. Only managed disks
+// support this; unmanaged (VHD-backed blob) disks return an error, since growing a VHD means
+// resizing its page blob and rewriting the VHD footer, which this plugin doesn't implement.
+func (plugin *azureDataDiskPlugin) ExpandVolumeDevice(spec *volume.Spec, newSize resource.Quantity, oldSize resource.Quantity) (resource.Quantity, error) {
+	volumeSource, err := getVolumeSource(spec)
+	if err != nil {
+		return oldSize, err
+	}
+
+	diskController, err := getDiskController(plugin.host)
+	if err != nil {
+		return oldSize, err
+	}
+
+	isManagedDisk := (*volumeSource.Kind == v1.AzureManagedDisk)
+	requestGiB := int(volume.RoundUpSize(newSize.Value(), 1024*1024*1024))
+
+	newSizeGiB, err := diskController.ResizeDisk(isManagedDisk, volumeSource.DataDiskURI, requestGiB)
+	if err != nil {
+		return oldSize, err
+	}
+	return resource.MustParse(fmt.Sprintf("%dGi", newSizeGiB)), nil
+}
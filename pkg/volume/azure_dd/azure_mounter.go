@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	libstrings "strings"
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
@@ -45,12 +46,28 @@ var _ volume.Mounter = &azureDiskMounter{}
 func (m *azureDiskMounter) GetAttributes() volume.Attributes {
 	volumeSource, _ := getVolumeSource(m.spec)
 	return volume.Attributes{
-		ReadOnly:        *volumeSource.ReadOnly,
-		Managed:         !*volumeSource.ReadOnly,
-		SupportsSELinux: true,
+		ReadOnly: *volumeSource.ReadOnly,
+		Managed:  !*volumeSource.ReadOnly,
+		// Ordinarily the kubelet relabels the volume itself (chcon) after mounting it. But when
+		// the PV's mountOptions already carry a context=/rootcontext= option, the filesystem was
+		// mounted with a single fixed SELinux context for everything under it - the usual reason
+		// to do that is a filesystem that doesn't support per-file security xattrs at all, which
+		// would make the kubelet's chcon-based relabel fail. Skip it in that case.
+		SupportsSELinux: !hasSELinuxContextMountOption(m.spec),
 	}
 }
 
+// hasSELinuxContextMountOption reports whether spec's effective mount options already set an
+// SELinux context (context= or rootcontext=) directly on the mount.
+func hasSELinuxContextMountOption(spec *volume.Spec) bool {
+	for _, opt := range volume.MountOptionFromSpec(spec) {
+		if libstrings.HasPrefix(opt, "context=") || libstrings.HasPrefix(opt, "rootcontext=") {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *azureDiskMounter) CanMount() error {
 	return nil
 }
@@ -139,13 +156,49 @@ func (m *azureDiskMounter) SetUpAt(dir string, fsGroup *int64) error {
 	}
 
 	if !*volumeSource.ReadOnly {
-		volume.SetVolumeOwnership(m, fsGroup)
+		policy, err := getFSGroupChangePolicy(m.spec)
+		if err != nil {
+			glog.Warningf("azureDisk - %v; falling back to a full recursive fsGroup chown", err)
+			policy = ""
+		}
+		switch policy {
+		case azureDiskFSGroupChangePolicySkip:
+			glog.V(4).Infof("azureDisk - skipping fsGroup ownership change for disk %s (fsgroup-change-policy: %s)", diskName, policy)
+		case azureDiskFSGroupChangePolicyTop:
+			if err := chownTopLevelOnly(dir, fsGroup); err != nil {
+				glog.Errorf("azureDisk - top-level fsGroup chown failed on disk %s dir %s: %v", diskName, dir, err)
+			}
+		default:
+			volume.SetVolumeOwnership(m, fsGroup)
+		}
 	}
 
 	glog.V(2).Infof("azureDisk - successfully mounted disk %s on %s", diskName, dir)
 	return nil
 }
 
+// chownTopLevelOnly applies fsGroup ownership to dir itself only, without walking its contents -
+// the azureDiskFSGroupChangePolicyTop policy above. Mirrors the symlink skip and uid-preserving
+// chown that volume.SetVolumeOwnership uses for every file when it does the full recursive walk.
+func chownTopLevelOnly(dir string, fsGroup *int64) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	if err := os.Chown(dir, -1, int(*fsGroup)); err != nil {
+		return err
+	}
+	return os.Chmod(dir, info.Mode()|0770|os.ModeSetgid)
+}
+
 func (u *azureDiskUnmounter) TearDown() error {
 	return u.TearDownAt(u.GetPath())
 }
@@ -18,14 +18,43 @@ package azure_dd
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1helper "k8s.io/kubernetes/pkg/api/v1/helper"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
 	"k8s.io/kubernetes/pkg/volume"
+	volumeutil "k8s.io/kubernetes/pkg/volume/util"
 )
 
+// azureDiskNameRegexp matches the characters Azure allows in a managed disk or blob name.
+var azureDiskNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// resolveDiskNameTemplate substitutes {pvc.name}/{pvc.namespace}/{pvc.uid} in the StorageClass
+// "disknametemplate" parameter with the values from the claim being provisioned, so operators can
+// give dynamically-provisioned disks a name they recognize in the Azure portal instead of the
+// opaque "<clusterName>-dynamic-pvc-<uid>" GenerateVolumeName produces by default.
+func resolveDiskNameTemplate(tmpl string, pvc *v1.PersistentVolumeClaim) (string, error) {
+	replacer := strings.NewReplacer(
+		"{pvc.name}", pvc.Name,
+		"{pvc.namespace}", pvc.Namespace,
+		"{pvc.uid}", string(pvc.UID),
+	)
+	name := replacer.Replace(tmpl)
+
+	if !azureDiskNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("azureDisk - diskNameTemplate %q resolved to %q, which is not a valid disk name (must match %s)", tmpl, name, azureDiskNameRegexp.String())
+	}
+	// maxLength = 79 - (4 for ".vhd"), same budget volume.GenerateVolumeName is capped to below
+	if len(name) > 75 {
+		return "", fmt.Errorf("azureDisk - diskNameTemplate %q resolved to %q, which is %d characters long; disk names must be 75 characters or less", tmpl, name, len(name))
+	}
+	return name, nil
+}
+
 type azureDiskProvisioner struct {
 	plugin  *azureDataDiskPlugin
 	options volume.VolumeOptions
@@ -87,11 +116,16 @@ func (p *azureDiskProvisioner) Provision() (*v1.PersistentVolume, error) {
 	}
 
 	var (
-		location, account          string
-		storageAccountType, fsType string
-		cachingMode                v1.AzureDataDiskCachingMode
-		strKind                    string
-		err                        error
+		location, account               string
+		storageAccountType, fsType      string
+		cachingMode                     v1.AzureDataDiskCachingMode
+		strKind                         string
+		mkfsOptions                     []string
+		configuredZone, configuredZones string
+		zonePresent, zonesPresent       bool
+		diskNameTemplate                string
+		mountProfile                    string
+		err                             error
 	)
 	// maxLength = 79 - (4 for ".vhd") = 75
 	name := volume.GenerateVolumeName(p.options.ClusterName, p.options.PVName, 75)
@@ -115,18 +149,38 @@ func (p *azureDiskProvisioner) Provision() (*v1.PersistentVolume, error) {
 			cachingMode = v1.AzureDataDiskCachingMode(v)
 		case volume.VolumeParameterFSType:
 			fsType = strings.ToLower(v)
+		case "mkfsoptions":
+			mkfsOptions = strings.Fields(v)
+		case "zone":
+			zonePresent = true
+			configuredZone = v
+		case "zones":
+			zonesPresent = true
+			configuredZones = v
+		case "disknametemplate":
+			diskNameTemplate = v
+		case "mountprofile":
+			mountProfile = v
 		default:
 			return nil, fmt.Errorf("AzureDisk - invalid option %s in storage class", k)
 		}
 	}
 
 	// normalize values
-	fsType = normalizeFsType(fsType)
+	fsType, err = normalizeFsType(fsType)
+	if err != nil {
+		return nil, err
+	}
+
 	skuName, err := normalizeStorageAccountType(storageAccountType)
 	if err != nil {
 		return nil, err
 	}
 
+	if kindOverride := p.options.PVC.Annotations[azureDiskKindAnnotation]; kindOverride != "" {
+		strKind = kindOverride
+	}
+
 	kind, err := normalizeKind(strFirstLetterToUpper(strKind))
 	if err != nil {
 		return nil, err
@@ -136,12 +190,53 @@ func (p *azureDiskProvisioner) Provision() (*v1.PersistentVolume, error) {
 		return nil, err
 	}
 
+	profileMountOptions, err := normalizeMountProfile(mountProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	if diskNameTemplate != "" {
+		name, err = resolveDiskNameTemplate(diskNameTemplate, p.options.PVC)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if zonePresent && zonesPresent {
+		return nil, fmt.Errorf("AzureDisk - zone and zones StorageClass parameters must not be used at the same time")
+	}
+
+	var selectedZone string
+	if zonePresent {
+		if err := volume.ValidateZone(configuredZone); err != nil {
+			return nil, err
+		}
+		selectedZone = configuredZone
+	} else if zonesPresent {
+		zones, err := volumeutil.ZonesToSet(configuredZones)
+		if err != nil {
+			return nil, err
+		}
+		// ChooseZoneForVolume hashes the PVC name to deterministically spread successive PVCs
+		// across the configured zones, the same round-robin-by-hash scheme gce_pd's provisioner
+		// uses for its own "zone"/"zones" parameters - this is what keeps a StatefulSet's PVCs
+		// from all landing in the same zone.
+		selectedZone = volume.ChooseZoneForVolume(zones, p.options.PVC.Name)
+	}
+
 	diskController, err := getDiskController(p.plugin.host)
 	if err != nil {
 		return nil, err
 	}
 
 	// create disk
+	//
+	// NOTE: selectedZone (from the "zone"/"zones" StorageClass parameters) is not passed to ARM
+	// here - the vendored disk SDK's disk creation parameters have no Zones field, so this
+	// provider has no way to pin the disk itself to an Availability Zone at creation time (the
+	// same vendored-SDK gap documented on availabilityZone in azure_zones.go). All
+	// selectedZone can do below is steer scheduling: label the PV and set its node affinity so
+	// pods using it only land on nodes already in that zone.
 	diskURI := ""
 	if kind == v1.AzureManagedDisk {
 		diskURI, err = diskController.CreateManagedDisk(name, skuName, requestGB, *(p.options.CloudTags))
@@ -181,6 +276,7 @@ func (p *azureDiskProvisioner) Provision() (*v1.PersistentVolume, error) {
 			Labels: map[string]string{},
 			Annotations: map[string]string{
 				"volumehelper.VolumeDynamicallyCreatedByKey": "azure-disk-dynamic-provisioner",
+				azureDiskMkfsOptionsAnnotation:               strings.Join(mkfsOptions, " "),
 			},
 		},
 		Spec: v1.PersistentVolumeSpec{
@@ -198,8 +294,42 @@ func (p *azureDiskProvisioner) Provision() (*v1.PersistentVolume, error) {
 					FSType:      &fsType,
 				},
 			},
-			MountOptions: p.options.MountOptions,
+			// profileMountOptions are appended after the StorageClass's own mountOptions, so an
+			// explicit entry there (e.g. a caching-mode-appropriate "nobarrier") always comes
+			// first and any conflicting duplicate later in the list simply overrides it at mount
+			// time, the same precedence mount(8) itself gives repeated options.
+			MountOptions: append(append([]string{}, p.options.MountOptions...), profileMountOptions...),
 		},
 	}
+
+	// Stamp the region label whenever the cloud has a Location configured (and the zone label when a zone was selected above)
+	// so zone-aware scheduling and any zone/region-based topology work without relying on the
+	// PersistentVolumeLabel admission controller, which has no Azure support to fall back on.
+	if az, err := getCloud(p.plugin.host); err == nil && az.Location != "" {
+		pv.Labels[kubeletapis.LabelZoneRegion] = az.Location
+	}
+
+	if selectedZone != "" {
+		pv.Labels[kubeletapis.LabelZoneFailureDomain] = selectedZone
+		nodeAffinity := &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{
+					{
+						MatchExpressions: []v1.NodeSelectorRequirement{
+							{
+								Key:      kubeletapis.LabelZoneFailureDomain,
+								Operator: v1.NodeSelectorOpIn,
+								Values:   []string{selectedZone},
+							},
+						},
+					},
+				},
+			},
+		}
+		if err := v1helper.StorageNodeAffinityToAlphaAnnotation(pv.Annotations, nodeAffinity); err != nil {
+			return nil, fmt.Errorf("AzureDisk - failed to set node affinity for zone %s: %v", selectedZone, err)
+		}
+	}
+
 	return pv, nil
 }
@@ -0,0 +1,28 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package azure_dd contains the internal representation of Azure DataDisk
+// (both blob-backed and managed) persistent volumes.
+//
+// NOTE: there is no CSI (Container Storage Interface) migration path in this
+// tree yet - no pkg/volume/csi package, no CSIPersistentVolumeSource API
+// type, and no CSIMigration feature gate exist anywhere in this codebase for
+// an azure-disk<->CSI translator to plug into. Translating an
+// AzureDiskVolumeSource to its CSI equivalent (and back) only means
+// something once that machinery lands; until then, this plugin remains the
+// sole in-tree path for Azure disks, the same way pkg/volume/azure_file
+// remains the sole in-tree path for Azure Files.
+package azure_dd // import "k8s.io/kubernetes/pkg/volume/azure_dd"
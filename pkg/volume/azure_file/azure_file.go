@@ -20,10 +20,12 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/kubernetes/pkg/util/mount"
 	kstrings "k8s.io/kubernetes/pkg/util/strings"
 	"k8s.io/kubernetes/pkg/volume"
@@ -48,6 +50,24 @@ var _ volume.PersistentVolumePlugin = &azureFilePlugin{}
 
 const (
 	azureFilePluginName = "kubernetes.io/azure-file"
+
+	// azureFileProtocolAnnotation records the wire protocol a dynamically provisioned share
+	// was created for, since AzureFilePersistentVolumeSource has no field for it. Absent
+	// (e.g. on statically created PVs), the mounter defaults to CIFS.
+	azureFileProtocolAnnotation = "volume.beta.kubernetes.io/azure-file-protocol"
+
+	// azureFileShareSnapshotAnnotation names a share snapshot (in Azure's
+	// "GMT-yyyy.mm.dd-hh.mm.ss" form) to mount read-only instead of the live share, for a PV
+	// that points at a point-in-time backup rather than the current share contents.
+	azureFileShareSnapshotAnnotation = "volume.beta.kubernetes.io/azure-file-share-snapshot"
+
+	// azureFileLargeSharesAnnotation records that a dynamically provisioned share's backing
+	// account was created (or referenced) with the largeFileShares feature enabled, so
+	// expansion beyond maxStandardFileShareSizeGiB is safe to allow.
+	azureFileLargeSharesAnnotation = "volume.beta.kubernetes.io/azure-file-large-shares"
+
+	nfsProtocol  = "nfs"
+	cifsProtocol = "cifs"
 )
 
 func getPath(uid types.UID, volName string, host volume.VolumeHost) string {
@@ -108,12 +128,20 @@ func (plugin *azureFilePlugin) newMounterInternal(spec *volume.Spec, pod *v1.Pod
 		return nil, err
 	}
 	secretName, secretNamespace, err := getSecretNameAndNamespace(spec, pod.Namespace)
+	snapshot := getFileShareSnapshot(spec)
+	if snapshot != "" {
+		// A share snapshot is a read-only, point-in-time view of the share it was taken
+		// from: force ReadOnly regardless of what the pod/PV requested.
+		readOnly = true
+	}
 	return &azureFileMounter{
 		azureFile: &azureFile{
-			volName:         spec.Name(),
-			mounter:         mounter,
-			pod:             pod,
-			plugin:          plugin,
+			volName: spec.Name(),
+			mounter: mounter,
+			pod:     pod,
+			plugin:  plugin,
+			// A CIFS/NFS client reports the share's actual Azure-enforced quota and current
+			// usage through statfs, so capacity/usage metrics need no separate share-stats call.
 			MetricsProvider: volume.NewMetricsStatFS(getPath(pod.UID, spec.Name(), plugin.host)),
 		},
 		util:            util,
@@ -122,6 +150,8 @@ func (plugin *azureFilePlugin) newMounterInternal(spec *volume.Spec, pod *v1.Pod
 		shareName:       share,
 		readOnly:        readOnly,
 		mountOptions:    volume.MountOptionFromSpec(spec),
+		protocol:        getFileShareProtocol(spec),
+		shareSnapshot:   snapshot,
 	}, nil
 }
 
@@ -174,6 +204,8 @@ type azureFileMounter struct {
 	shareName       string
 	readOnly        bool
 	mountOptions    []string
+	protocol        string
+	shareSnapshot   string
 }
 
 var _ volume.Mounter = &azureFileMounter{}
@@ -207,6 +239,10 @@ func (b *azureFileMounter) SetUpAt(dir string, fsGroup *int64) error {
 	if !notMnt {
 		return nil
 	}
+	if b.shareSnapshot != "" && b.protocol == nfsProtocol {
+		return fmt.Errorf("mounting a share snapshot is not supported for protocol %q", nfsProtocol)
+	}
+
 	var accountKey, accountName string
 	if accountName, accountKey, err = b.util.GetAzureCredentials(b.plugin.host, b.secretNamespace, b.secretName); err != nil {
 		return err
@@ -214,22 +250,36 @@ func (b *azureFileMounter) SetUpAt(dir string, fsGroup *int64) error {
 
 	mountOptions := []string{}
 	source := ""
+	fsType := "cifs"
 	osSeparator := string(os.PathSeparator)
-	source = fmt.Sprintf("%s%s%s.file.%s%s%s", osSeparator, osSeparator, accountName, getStorageEndpointSuffix(b.plugin.host.GetCloudProvider()), osSeparator, b.shareName)
-
-	if runtime.GOOS == "windows" {
-		mountOptions = []string{fmt.Sprintf("AZURE\\%s", accountName), accountKey}
-	} else {
-		os.MkdirAll(dir, 0700)
-		// parameters suggested by https://azure.microsoft.com/en-us/documentation/articles/storage-how-to-use-files-linux/
-		options := []string{fmt.Sprintf("vers=3.0,username=%s,password=%s,dir_mode=0700,file_mode=0700", accountName, accountKey)}
+	endpointSuffix := getStorageEndpointSuffix(b.plugin.host.GetCloudProvider())
+
+	if runtime.GOOS != "windows" && b.protocol == nfsProtocol {
+		// NFS shares are addressed host:/export style, and Azure Files authorizes NFS
+		// access by network (private endpoint / firewall rule) rather than by account key,
+		// so no username/password mount options are needed.
+		fsType = "nfs"
+		source = fmt.Sprintf("%s.file.%s:/%s/%s", accountName, endpointSuffix, accountName, b.shareName)
+		options := []string{}
 		if b.readOnly {
 			options = append(options, "ro")
 		}
 		mountOptions = volume.JoinMountOptions(b.mountOptions, options)
+	} else {
+		source = fmt.Sprintf("%s%s%s.file.%s%s%s", osSeparator, osSeparator, accountName, endpointSuffix, osSeparator, b.shareName)
+		if runtime.GOOS == "windows" {
+			mountOptions = []string{fmt.Sprintf("AZURE\\%s", accountName), accountKey}
+		} else {
+			os.MkdirAll(dir, 0700)
+			userOptions := b.mountOptions
+			if b.shareSnapshot != "" {
+				userOptions = append(append([]string{}, userOptions...), fmt.Sprintf("snapshot=%s", b.shareSnapshot))
+			}
+			mountOptions = buildCIFSMountOptions(accountName, accountKey, userOptions, b.readOnly)
+		}
 	}
 
-	err = b.mounter.Mount(source, dir, "cifs", mountOptions)
+	err = b.mounter.Mount(source, dir, fsType, mountOptions)
 	if err != nil {
 		notMnt, mntErr := b.mounter.IsLikelyNotMountPoint(dir)
 		if mntErr != nil {
@@ -272,6 +322,54 @@ func (c *azureFileUnmounter) TearDownAt(dir string) error {
 	return util.UnmountPath(dir, c.mounter)
 }
 
+// defaultCIFSMountOptions are applied unless the PV's mountOptions already set the same
+// key, so operators can override protocol version, mode bits, etc. while still getting sane
+// defaults out of the box.
+var defaultCIFSMountOptions = []string{"vers=3.0", "dir_mode=0700", "file_mode=0700"}
+
+// buildCIFSMountOptions merges the CIFS options this plugin needs (credentials, its
+// defaults) with the caller-supplied mount options, letting the latter win so SMB3 features
+// like sealing (encryption), mfsymlinks, actimeo and uid/gid can flow through from the PV
+// spec unmodified. Session encryption ("seal") is turned on by default whenever the
+// negotiated protocol is SMB3.0 or newer, since that's the only version range that supports
+// it; operators can opt out with an explicit noseal option.
+func buildCIFSMountOptions(accountName, accountKey string, userOptions []string, readOnly bool) []string {
+	userKeys := sets.NewString()
+	vers := "3.0"
+	for _, opt := range userOptions {
+		key, value, hasValue := splitMountOption(opt)
+		userKeys.Insert(key)
+		if hasValue && key == "vers" {
+			vers = value
+		}
+	}
+
+	options := []string{fmt.Sprintf("username=%s", accountName), fmt.Sprintf("password=%s", accountKey)}
+	for _, def := range defaultCIFSMountOptions {
+		key, _, _ := splitMountOption(def)
+		if !userKeys.Has(key) {
+			options = append(options, def)
+		}
+	}
+	if !userKeys.Has("seal") && !userKeys.Has("noseal") &&
+		!strings.HasPrefix(vers, "1.") && !strings.HasPrefix(vers, "2.") {
+		options = append(options, "seal")
+	}
+	if readOnly && !userKeys.Has("ro") && !userKeys.Has("rw") {
+		options = append(options, "ro")
+	}
+	return volume.JoinMountOptions(userOptions, options)
+}
+
+// splitMountOption splits a "key=value" mount option into its key and value. Flag-style
+// options ("seal", "ro") are returned as their own key with hasValue false.
+func splitMountOption(opt string) (key, value string, hasValue bool) {
+	if idx := strings.Index(opt, "="); idx >= 0 {
+		return opt[:idx], opt[idx+1:], true
+	}
+	return opt, "", false
+}
+
 func getVolumeSource(spec *volume.Spec) (string, bool, error) {
 	if spec.Volume != nil && spec.Volume.AzureFile != nil {
 		share := spec.Volume.AzureFile.ShareName
@@ -286,6 +384,26 @@ func getVolumeSource(spec *volume.Spec) (string, bool, error) {
 	return "", false, fmt.Errorf("Spec does not reference an AzureFile volume type")
 }
 
+// getFileShareProtocol returns the wire protocol a PV was provisioned for, defaulting to
+// CIFS when the PV predates protocol selection or was created outside dynamic provisioning.
+func getFileShareProtocol(spec *volume.Spec) string {
+	if spec.PersistentVolume != nil {
+		if protocol, ok := spec.PersistentVolume.Annotations[azureFileProtocolAnnotation]; ok {
+			return protocol
+		}
+	}
+	return cifsProtocol
+}
+
+// getFileShareSnapshot returns the share snapshot a PV should mount instead of the live
+// share, or "" if it should mount the live share.
+func getFileShareSnapshot(spec *volume.Spec) string {
+	if spec.PersistentVolume != nil {
+		return spec.PersistentVolume.Annotations[azureFileShareSnapshotAnnotation]
+	}
+	return ""
+}
+
 func getSecretNameAndNamespace(spec *volume.Spec, defaultNamespace string) (string, string, error) {
 	secretName := ""
 	secretNamespace := ""
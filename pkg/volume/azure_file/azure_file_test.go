@@ -86,18 +86,24 @@ func contains(modes []v1.PersistentVolumeAccessMode, mode v1.PersistentVolumeAcc
 }
 
 func getAzureTestCloud(t *testing.T) *azure.Cloud {
+	// tenantId/subscriptionId/aadClientId are valid-looking (but not real) UUIDs and
+	// resourceGroup is non-empty, since azure.NewCloud validates them once real AAD credentials
+	// (aadClientId/Secret) are present, per validateAzureConfig in the azure package.
 	config := `{
-                "aadClientId": "--aad-client-id--",
+                "tenantId": "00000000-0000-0000-0000-000000000001",
+                "subscriptionId": "00000000-0000-0000-0000-000000000002",
+                "resourceGroup": "--resource-group--",
+                "aadClientId": "00000000-0000-0000-0000-000000000003",
                 "aadClientSecret": "--aad-client-secret--"
         }`
 	configReader := strings.NewReader(config)
 	cloud, err := azure.NewCloud(configReader)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	azureCloud, ok := cloud.(*azure.Cloud)
 	if !ok {
-		t.Error("NewCloud returned incorrect type")
+		t.Fatal("NewCloud returned incorrect type")
 	}
 	return azureCloud
 }
@@ -249,7 +255,7 @@ type fakeAzureSvc struct{}
 func (s *fakeAzureSvc) GetAzureCredentials(host volume.VolumeHost, nameSpace, secretName string) (string, string, error) {
 	return "name", "key", nil
 }
-func (s *fakeAzureSvc) SetAzureCredentials(host volume.VolumeHost, nameSpace, accountName, accountKey string) (string, error) {
+func (s *fakeAzureSvc) SetAzureCredentials(host volume.VolumeHost, nameSpace, accountName, accountKey, secretName string) (string, error) {
 	return "secret", nil
 }
 
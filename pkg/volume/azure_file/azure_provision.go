@@ -18,6 +18,7 @@ package azure_file
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/golang/glog"
@@ -33,16 +34,39 @@ import (
 
 var _ volume.DeletableVolumePlugin = &azureFilePlugin{}
 var _ volume.ProvisionableVolumePlugin = &azureFilePlugin{}
+var _ volume.ExpandableVolumePlugin = &azureFilePlugin{}
 
 // Abstract interface to file share operations.
 // azure cloud provider should implement it
 type azureCloudProvider interface {
 	// create a file share
-	CreateFileShare(name, storageAccount, storageType, location string, requestGB int) (string, string, error)
+	CreateFileShare(name, storageAccount, storageType, location string, requestGB int, privateEndpointSubnet string, enableLargeFileShares bool) (string, string, error)
 	// delete a file share
 	DeleteFileShare(accountName, key, name string) error
+	// resize a file share
+	ResizeFileShare(accountName, key, name string, sizeGB int) error
+	// create a snapshot of a file share
+	CreateShareSnapshot(accountName, key, name string) (string, error)
+	// delete a snapshot of a file share
+	DeleteShareSnapshot(accountName, key, name, snapshot string) error
+	// list the snapshots of a file share
+	ListShareSnapshots(accountName, key, name string) ([]string, error)
 }
 
+const (
+	// maxStandardFileShareSizeGiB is the quota ceiling for a share on an account that hasn't
+	// opted into the largeFileShares feature.
+	maxStandardFileShareSizeGiB = 5120
+	// maxLargeFileShareSizeGiB is the quota ceiling for a share on an account with
+	// largeFileShares enabled.
+	maxLargeFileShareSizeGiB = 102400
+
+	// softDeleteConflictFail fails provisioning when the share name is soft-deleted, naming the
+	// conflict so an operator can resolve it. It's the only policy this plugin can carry out: the
+	// vendored storage SDK has no way to undelete or purge a soft-deleted share on our behalf.
+	softDeleteConflictFail = "fail"
+)
+
 type azureFileDeleter struct {
 	*azureFile
 	accountName, accountKey, shareName string
@@ -134,7 +158,9 @@ func (a *azureFileProvisioner) Provision() (*v1.PersistentVolume, error) {
 		return nil, fmt.Errorf("invalid AccessModes %v: only AccessModes %v are supported", a.options.PVC.Spec.AccessModes, a.plugin.GetAccessModes())
 	}
 
-	var sku, location, account string
+	var sku, location, account, protocol, secretName, privateEndpointSubnet string
+	var enableLargeFileShares bool
+	onSoftDeleteConflict := softDeleteConflictFail
 
 	// File share name has a length limit of 63, and it cannot contain two consecutive '-'s.
 	name := volume.GenerateVolumeName(a.options.ClusterName, a.options.PVName, 63)
@@ -155,6 +181,20 @@ func (a *azureFileProvisioner) Provision() (*v1.PersistentVolume, error) {
 			account = v
 		case "secretnamespace":
 			secretNamespace = v
+		case "secretname":
+			secretName = v
+		case "protocol":
+			protocol = strings.ToLower(v)
+		case "privateendpointsubnet":
+			privateEndpointSubnet = v
+		case "largefilesharesenabled":
+			var err error
+			enableLargeFileShares, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for parameter largeFileSharesEnabled: %v", v, err)
+			}
+		case "onsoftdeleteconflict":
+			onSoftDeleteConflict = strings.ToLower(v)
 		default:
 			return nil, fmt.Errorf("invalid option %q for volume plugin %s", k, a.plugin.GetPluginName())
 		}
@@ -164,13 +204,44 @@ func (a *azureFileProvisioner) Provision() (*v1.PersistentVolume, error) {
 		return nil, fmt.Errorf("claim.Spec.Selector is not supported for dynamic provisioning on Azure file")
 	}
 
-	account, key, err := a.azureProvider.CreateFileShare(name, account, sku, location, requestGB)
+	switch protocol {
+	case "", cifsProtocol:
+		protocol = cifsProtocol
+	case nfsProtocol:
+		// NFS shares require a premium, NFS-enabled storage account, which this tree's
+		// vendored storage SDK cannot create on demand (see CreateFileShare's Premium_LRS
+		// handling). Require the operator to pre-create one and reference it explicitly.
+		if account == "" {
+			return nil, fmt.Errorf("protocol %q requires a pre-created NFS-enabled premium storage account referenced via the storageAccount parameter", nfsProtocol)
+		}
+	default:
+		return nil, fmt.Errorf("invalid protocol %q for volume plugin %s: must be %q or %q", protocol, a.plugin.GetPluginName(), cifsProtocol, nfsProtocol)
+	}
+
+	if onSoftDeleteConflict != softDeleteConflictFail {
+		return nil, fmt.Errorf("invalid value %q for parameter onSoftDeleteConflict: only %q is supported, since "+
+			"the vendored Azure storage SDK can't undelete or purge a soft-deleted share on this plugin's behalf",
+			onSoftDeleteConflict, softDeleteConflictFail)
+	}
+
+	maxSizeGiB := maxStandardFileShareSizeGiB
+	shareKind := "standard"
+	if enableLargeFileShares {
+		maxSizeGiB = maxLargeFileShareSizeGiB
+		shareKind = "large"
+	}
+	if requestGB > maxSizeGiB {
+		return nil, fmt.Errorf("requested size %dGiB exceeds the %dGiB limit for a %s Azure file share", requestGB, maxSizeGiB, shareKind)
+	}
+
+	account, key, err := a.azureProvider.CreateFileShare(name, account, sku, location, requestGB, privateEndpointSubnet, enableLargeFileShares)
 	if err != nil {
 		return nil, err
 	}
 
-	// create a secret for storage account and key
-	secretName, err := a.util.SetAzureCredentials(a.plugin.host, secretNamespace, account, key)
+	// create a secret for storage account and key, reusing secretName if it was set and
+	// already exists (e.g. pre-created by an admin in a restricted namespace)
+	secretName, err = a.util.SetAzureCredentials(a.plugin.host, secretNamespace, account, key, secretName)
 	if err != nil {
 		return nil, err
 	}
@@ -181,6 +252,8 @@ func (a *azureFileProvisioner) Provision() (*v1.PersistentVolume, error) {
 			Labels: map[string]string{},
 			Annotations: map[string]string{
 				volumehelper.VolumeDynamicallyCreatedByKey: "azure-file-dynamic-provisioner",
+				azureFileProtocolAnnotation:                protocol,
+				azureFileLargeSharesAnnotation:             strconv.FormatBool(enableLargeFileShares),
 			},
 		},
 		Spec: v1.PersistentVolumeSpec{
@@ -202,6 +275,113 @@ func (a *azureFileProvisioner) Provision() (*v1.PersistentVolume, error) {
 	return pv, nil
 }
 
+// shareCredentials resolves the storage account name and key backing an azure-file PV, for
+// use by callers that operate on the share out-of-band from mount/unmount (snapshotting,
+// expansion).
+func (plugin *azureFilePlugin) shareCredentials(spec *volume.Spec) (accountName, accountKey, shareName string, err error) {
+	if spec.PersistentVolume == nil || spec.PersistentVolume.Spec.AzureFile == nil {
+		return "", "", "", fmt.Errorf("invalid PV spec")
+	}
+	secretName, secretNamespace, err := getSecretNameAndNamespace(spec, spec.PersistentVolume.Spec.ClaimRef.Namespace)
+	if err != nil {
+		return "", "", "", err
+	}
+	accountName, accountKey, err = (&azureSvc{}).GetAzureCredentials(plugin.host, secretNamespace, secretName)
+	if err != nil {
+		return "", "", "", err
+	}
+	return accountName, accountKey, spec.PersistentVolume.Spec.AzureFile.ShareName, nil
+}
+
+// CreateShareSnapshot creates a point-in-time, read-only snapshot of an azure-file PV's
+// backing share, so external backup tooling can orchestrate share-level backups without
+// reaching into the cloud provider directly.
+func (plugin *azureFilePlugin) CreateShareSnapshot(spec *volume.Spec) (string, error) {
+	azure, err := getAzureCloudProvider(plugin.host.GetCloudProvider())
+	if err != nil {
+		return "", err
+	}
+	accountName, accountKey, shareName, err := plugin.shareCredentials(spec)
+	if err != nil {
+		return "", err
+	}
+	return azure.CreateShareSnapshot(accountName, accountKey, shareName)
+}
+
+// DeleteShareSnapshot deletes a previously created snapshot of an azure-file PV's backing
+// share.
+func (plugin *azureFilePlugin) DeleteShareSnapshot(spec *volume.Spec, snapshot string) error {
+	azure, err := getAzureCloudProvider(plugin.host.GetCloudProvider())
+	if err != nil {
+		return err
+	}
+	accountName, accountKey, shareName, err := plugin.shareCredentials(spec)
+	if err != nil {
+		return err
+	}
+	return azure.DeleteShareSnapshot(accountName, accountKey, shareName, snapshot)
+}
+
+// ListShareSnapshots lists the snapshots that exist for an azure-file PV's backing share.
+func (plugin *azureFilePlugin) ListShareSnapshots(spec *volume.Spec) ([]string, error) {
+	azure, err := getAzureCloudProvider(plugin.host.GetCloudProvider())
+	if err != nil {
+		return nil, err
+	}
+	accountName, accountKey, shareName, err := plugin.shareCredentials(spec)
+	if err != nil {
+		return nil, err
+	}
+	return azure.ListShareSnapshots(accountName, accountKey, shareName)
+}
+
+// RequiresFSResize reports that no node-side filesystem resize is needed: an Azure file
+// share's capacity is enforced entirely by its quota, so growing the quota is sufficient.
+func (plugin *azureFilePlugin) RequiresFSResize() bool {
+	return false
+}
+
+// ExpandVolumeDevice grows an azure-file PV's backing share to newSize by raising its quota.
+func (plugin *azureFilePlugin) ExpandVolumeDevice(spec *volume.Spec, newSize resource.Quantity, oldSize resource.Quantity) (resource.Quantity, error) {
+	if spec.PersistentVolume == nil || spec.PersistentVolume.Spec.AzureFile == nil {
+		return oldSize, fmt.Errorf("invalid PV spec")
+	}
+
+	azure, err := getAzureCloudProvider(plugin.host.GetCloudProvider())
+	if err != nil {
+		glog.V(4).Infof("failed to get azure provider")
+		return oldSize, err
+	}
+
+	maxSizeGiB := maxStandardFileShareSizeGiB
+	shareKind := "standard"
+	if spec.PersistentVolume.Annotations[azureFileLargeSharesAnnotation] == "true" {
+		maxSizeGiB = maxLargeFileShareSizeGiB
+		shareKind = "large"
+	}
+	requestGB := int(volume.RoundUpSize(newSize.Value(), 1024*1024*1024))
+	if requestGB > maxSizeGiB {
+		return oldSize, fmt.Errorf("requested size %dGiB exceeds the %dGiB limit for a %s Azure file share", requestGB, maxSizeGiB, shareKind)
+	}
+
+	shareName := spec.PersistentVolume.Spec.AzureFile.ShareName
+	secretName, secretNamespace, err := getSecretNameAndNamespace(spec, spec.PersistentVolume.Spec.ClaimRef.Namespace)
+	if err != nil {
+		return oldSize, err
+	}
+	accountName, accountKey, err := (&azureSvc{}).GetAzureCredentials(plugin.host, secretNamespace, secretName)
+	if err != nil {
+		return oldSize, err
+	}
+
+	glog.V(2).Infof("expanding share %s in account %s to %dGiB", shareName, accountName, requestGB)
+	if err := azure.ResizeFileShare(accountName, accountKey, shareName, requestGB); err != nil {
+		return oldSize, err
+	}
+
+	return resource.MustParse(fmt.Sprintf("%dGi", requestGB)), nil
+}
+
 // Return cloud provider
 func getAzureCloudProvider(cloudProvider cloudprovider.Interface) (azureCloudProvider, error) {
 	azureCloudProvider, ok := cloudProvider.(*azure.Cloud)
@@ -28,7 +28,7 @@ import (
 // Abstract interface to azure file operations.
 type azureUtil interface {
 	GetAzureCredentials(host volume.VolumeHost, nameSpace, secretName string) (string, string, error)
-	SetAzureCredentials(host volume.VolumeHost, nameSpace, accountName, accountKey string) (string, error)
+	SetAzureCredentials(host volume.VolumeHost, nameSpace, accountName, accountKey, secretName string) (string, error)
 }
 
 type azureSvc struct{}
@@ -58,12 +58,18 @@ func (s *azureSvc) GetAzureCredentials(host volume.VolumeHost, nameSpace, secret
 	return accountName, accountKey, nil
 }
 
-func (s *azureSvc) SetAzureCredentials(host volume.VolumeHost, nameSpace, accountName, accountKey string) (string, error) {
+// SetAzureCredentials writes a storage account's credentials into a secret, creating it if
+// it doesn't already exist. If secretName is empty, a name is generated from accountName; a
+// pre-created secret with that name (e.g. one an admin placed into a restricted namespace
+// ahead of time) is left untouched rather than overwritten.
+func (s *azureSvc) SetAzureCredentials(host volume.VolumeHost, nameSpace, accountName, accountKey, secretName string) (string, error) {
 	kubeClient := host.GetKubeClient()
 	if kubeClient == nil {
 		return "", fmt.Errorf("Cannot get kube client")
 	}
-	secretName := "azure-storage-account-" + accountName + "-secret"
+	if secretName == "" {
+		secretName = "azure-storage-account-" + accountName + "-secret"
+	}
 	secret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: nameSpace,
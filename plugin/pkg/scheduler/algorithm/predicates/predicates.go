@@ -20,7 +20,9 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -180,6 +182,11 @@ type MaxPDVolumeCountChecker struct {
 	maxVolumes int
 	pvInfo     PersistentVolumeInfo
 	pvcInfo    PersistentVolumeClaimInfo
+	// maxVolumesForNode, when non-nil, is tried before falling back to maxVolumes so a single
+	// predicate instance can enforce a per-node limit (e.g. an Azure VM-size-specific attachable
+	// disk count) instead of one fixed limit for every node in the cluster. It returns ok=false to
+	// fall back to maxVolumes, e.g. when the node lacks whatever it derives the limit from.
+	maxVolumesForNode func(node *v1.Node) (int, bool)
 }
 
 // VolumeFilter contains information on how to filter PD Volumes when checking PD Volume caps
@@ -197,11 +204,19 @@ type VolumeFilter struct {
 // types, counts the number of unique volumes, and rejects the new pod if it would place the total count over
 // the maximum.
 func NewMaxPDVolumeCountPredicate(filter VolumeFilter, maxVolumes int, pvInfo PersistentVolumeInfo, pvcInfo PersistentVolumeClaimInfo) algorithm.FitPredicate {
+	return NewMaxPDVolumeCountPredicateForNode(filter, maxVolumes, nil, pvInfo, pvcInfo)
+}
+
+// NewMaxPDVolumeCountPredicateForNode is NewMaxPDVolumeCountPredicate, plus an optional
+// maxVolumesForNode used to look up a per-node override of maxVolumes; see
+// MaxPDVolumeCountChecker.maxVolumesForNode.
+func NewMaxPDVolumeCountPredicateForNode(filter VolumeFilter, maxVolumes int, maxVolumesForNode func(node *v1.Node) (int, bool), pvInfo PersistentVolumeInfo, pvcInfo PersistentVolumeClaimInfo) algorithm.FitPredicate {
 	c := &MaxPDVolumeCountChecker{
-		filter:     filter,
-		maxVolumes: maxVolumes,
-		pvInfo:     pvInfo,
-		pvcInfo:    pvcInfo,
+		filter:            filter,
+		maxVolumes:        maxVolumes,
+		pvInfo:            pvInfo,
+		pvcInfo:           pvcInfo,
+		maxVolumesForNode: maxVolumesForNode,
 	}
 
 	return c.predicate
@@ -297,8 +312,17 @@ func (c *MaxPDVolumeCountChecker) predicate(pod *v1.Pod, meta algorithm.Predicat
 
 	numNewVolumes := len(newVolumes)
 
-	if numExistingVolumes+numNewVolumes > c.maxVolumes {
-		// violates MaxEBSVolumeCount or MaxGCEPDVolumeCount
+	maxVolumes := c.maxVolumes
+	if c.maxVolumesForNode != nil {
+		if node := nodeInfo.Node(); node != nil {
+			if limit, ok := c.maxVolumesForNode(node); ok {
+				maxVolumes = limit
+			}
+		}
+	}
+
+	if numExistingVolumes+numNewVolumes > maxVolumes {
+		// violates MaxEBSVolumeCount, MaxGCEPDVolumeCount or MaxAzureDiskVolumeCount
 		return false, []algorithm.PredicateFailureReason{ErrMaxVolumeCountExceeded}, nil
 	}
 
@@ -356,6 +380,40 @@ var AzureDiskVolumeFilter VolumeFilter = VolumeFilter{
 	},
 }
 
+// azureVMSizeVCPURegexp pulls the vCPU count out of an Azure VM size name, e.g. "2" out of
+// "Standard_D2s_v3". Constrained-core sizes such as "Standard_E32-16s_v3" list the
+// pre-constraint vCPU count first, which is what Azure's per-size disk limit is actually based
+// on, so taking the first number in the string is intentional, not just convenient.
+var azureVMSizeVCPURegexp = regexp.MustCompile(`\d+`)
+
+// MaxAzureDiskVolumesForNode derives a best-effort per-node override of DefaultMaxAzureDiskVolumes
+// from the node's beta.kubernetes.io/instance-type label, using Azure's documented rule that most
+// current-generation VM sizes allow 2 data disks per vCPU, up to a cap of 64. It returns ok=false
+// - falling back to the configured default - when the label is absent, or the size doesn't look
+// like one the 2x rule applies to (Basic-tier and a handful of legacy/specialty sizes don't follow
+// it), rather than risk under- or over-reporting a limit it can't back up.
+func MaxAzureDiskVolumesForNode(node *v1.Node) (int, bool) {
+	vmSize := node.Labels[kubeletapis.LabelInstanceType]
+	if vmSize == "" || strings.HasPrefix(vmSize, "Basic_") {
+		return 0, false
+	}
+
+	match := azureVMSizeVCPURegexp.FindString(vmSize)
+	if match == "" {
+		return 0, false
+	}
+	vcpu, err := strconv.Atoi(match)
+	if err != nil || vcpu <= 0 {
+		return 0, false
+	}
+
+	maxDisks := vcpu * 2
+	if maxDisks > 64 {
+		maxDisks = 64
+	}
+	return maxDisks, true
+}
+
 type VolumeZoneChecker struct {
 	pvInfo  PersistentVolumeInfo
 	pvcInfo PersistentVolumeClaimInfo
@@ -1904,6 +1904,65 @@ func newPodWithPort(hostPorts ...int) *v1.Pod {
 	}
 }
 
+func TestMaxAzureDiskVolumesForNode(t *testing.T) {
+	tests := []struct {
+		vmSize       string
+		labelPresent bool
+		wantOk       bool
+		wantMax      int
+		test         string
+	}{
+		{
+			labelPresent: false,
+			wantOk:       false,
+			test:         "unlabeled node falls back to the default",
+		},
+		{
+			vmSize:       "Basic_A2",
+			labelPresent: true,
+			wantOk:       false,
+			test:         "Basic-tier size doesn't follow the 2x-vcpu rule",
+		},
+		{
+			vmSize:       "not-a-real-vm-size",
+			labelPresent: true,
+			wantOk:       false,
+			test:         "malformed/non-numeric size string has no vCPU count to parse",
+		},
+		{
+			vmSize:       "Standard_D2s_v3",
+			labelPresent: true,
+			wantOk:       true,
+			wantMax:      4,
+			test:         "2 vCPUs allows 4 data disks",
+		},
+		{
+			vmSize:       "Standard_M128s",
+			labelPresent: true,
+			wantOk:       true,
+			wantMax:      64,
+			test:         "large size is capped at 64",
+		},
+	}
+
+	for _, test := range tests {
+		labels := map[string]string{}
+		if test.labelPresent {
+			labels[kubeletapis.LabelInstanceType] = test.vmSize
+		}
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: labels}}
+
+		max, ok := MaxAzureDiskVolumesForNode(node)
+		if ok != test.wantOk {
+			t.Errorf("%s: got ok=%v, want %v", test.test, ok, test.wantOk)
+			continue
+		}
+		if ok && max != test.wantMax {
+			t.Errorf("%s: got max=%d, want %d", test.test, max, test.wantMax)
+		}
+	}
+}
+
 func TestRunGeneralPredicates(t *testing.T) {
 	resourceTests := []struct {
 		pod      *v1.Pod
@@ -37,9 +37,9 @@ const (
 	// DefaultMaxGCEPDVolumes defines the maximum number of PD Volumes for GCE
 	// GCE instances can have up to 16 PD volumes attached.
 	DefaultMaxGCEPDVolumes = 16
-	// DefaultMaxAzureDiskVolumes defines the maximum number of PD Volumes for Azure
-	// Larger Azure VMs can actually have much more disks attached.
-	// TODO We should determine the max based on VM size
+	// DefaultMaxAzureDiskVolumes defines the maximum number of PD Volumes for Azure.
+	// Used as a fallback when predicates.MaxAzureDiskVolumesForNode can't derive a VM-size-specific
+	// limit for a node (e.g. its instance-type label is missing or unrecognized).
 	DefaultMaxAzureDiskVolumes = 16
 	// ClusterAutoscalerProvider defines the default autoscaler provider
 	ClusterAutoscalerProvider = "ClusterAutoscalerProvider"
@@ -158,7 +158,10 @@ func defaultPredicates() sets.String {
 			func(args factory.PluginFactoryArgs) algorithm.FitPredicate {
 				// TODO: allow for generically parameterized scheduler predicates, because this is a bit ugly
 				maxVols := getMaxVols(DefaultMaxAzureDiskVolumes)
-				return predicates.NewMaxPDVolumeCountPredicate(predicates.AzureDiskVolumeFilter, maxVols, args.PVInfo, args.PVCInfo)
+				// Larger Azure VMs can attach more than DefaultMaxAzureDiskVolumes disks;
+				// MaxAzureDiskVolumesForNode derives the actual per-VM-size limit from the node's
+				// instance-type label where it can, and falls back to maxVols otherwise.
+				return predicates.NewMaxPDVolumeCountPredicateForNode(predicates.AzureDiskVolumeFilter, maxVols, predicates.MaxAzureDiskVolumesForNode, args.PVInfo, args.PVCInfo)
 			},
 		),
 		// Fit is determined by inter-pod affinity.